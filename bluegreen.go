@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// canSwap reports whether the server can be restarted without downtime.
+// Servers with replicas are restarted one replica at a time
+func (srv *Server) canSwap() bool {
+	if !(srv.conf.BlueGreen || srv.conf.Replicas > 1) || srv.conf.SocketActivation || srv.background || srv.getError() != nil {
+		return false
+	}
+
+	procs := srv.getProcesses()
+	for _, p := range procs {
+		if p.getState() != running {
+			return false
+		}
+	}
+
+	return len(procs) > 0
+}
+
+// swap starts a new process on a fresh port and switches the traffic to it once it is ready.
+// The current process keeps serving until then and is drained in the background.
+func (srv *Server) swap() error {
+	log.Printf("Swapping...%s", srv.host)
+
+	addr, err := findAvailablePort()
+	if err != nil {
+		return srv.swapFailed("start", err)
+	}
+
+	conf, err := srv.conf.withPort(addr.Port)
+	if err != nil {
+		return srv.swapFailed("start", err)
+	}
+
+	p := newProcess(srv.processHost(), conf)
+	srv.stderr.Reset()
+
+	if err := srv.runHooks("preStart", srv.conf.PreStart); err != nil {
+		return srv.swapFailed("start", err)
+	}
+
+	err = srv.startProcess(p)
+	if err == nil {
+		select {
+		case err = <-p.done:
+		default:
+		}
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%v\nError:%s\n", err, srv.stderr.ReadAll())
+		srv.stopProcess(p)
+		return srv.swapFailed("start", err)
+	}
+
+	old := srv.getProcess()
+	srv.setProcess(p)
+	srv.restartDependents()
+	srv.setWarning(warningSwap, "")
+	srv.notifyStatus(&statusMessage{Type: "build", Status: statusPassed, Packages: []string{}})
+	log.Println(srv.host, "...Swap completed", p.addr)
+
+	go srv.drain(old)
+	return nil
+}
+
+// drain stops a replaced process once the requests it is serving complete and runs the postStop hooks
+func (srv *Server) drain(old *process) {
+	old.retire()
+	// Let the requests that picked the old process register
+	<-time.After(10 * time.Millisecond)
+	old.pending.Wait()
+	srv.stopProcess(old)
+
+	if err := srv.runHooks("postStop", srv.conf.PostStop); err != nil {
+		log.Printf("%s: %v", srv.host, err)
+	}
+}
+
+// swapFailed reports a failed swap to the live reload clients. The current process keeps serving.
+func (srv *Server) swapFailed(step string, err error) error {
+	log.Printf("%s: %s failed, keeping the current process\n%v", srv.host, step, err)
+	srv.setWarning(warningSwap, err.Error())
+	srv.notifyStatus(&statusMessage{
+		Type:   "build",
+		Status: statusFailed,
+		Output: err.Error(),
+		URL:    srv.viewerURL(),
+	})
+	return err
+}
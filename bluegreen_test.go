@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSwap(t *testing.T) {
+	dir := t.TempDir()
+
+	var conf config
+	input := fmt.Sprintf(`{"server": [{"host": "localhost", "bin": "/bin/sh", "startup": ["-c", "echo ready; sleep 10"], "workingDir": %q, "blueGreen": true, "startupTimeout": 5, "healthCheck": {"type": "log", "pattern": "ready"}, "postStop": [["/bin/sh", "-c", "echo postStop > hooks.log"]]}]}`, dir)
+
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := newServer(build.Default, conf.Servers[0], 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := srv.getProcess()
+	defer func() {
+		srv.stopProcess(old)
+		srv.stopProcess(srv.getProcess())
+	}()
+
+	if !srv.canSwap() {
+		t.Fatal("Expected the server to swap")
+	}
+
+	// A request in flight delays the drain of the old process
+	old.acquire()
+
+	if err := srv.swap(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := srv.getProcess()
+	if p == old || p.addr == old.addr || !p.available() {
+		t.Fatalf("Expected a new process on another port: %s", p.addr)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if old.getState() != running {
+		t.Fatal("Expected the old process to serve its pending request")
+	}
+
+	old.release()
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if data, _ := ioutil.ReadFile(filepath.Join(dir, "hooks.log")); old.getState() == exited && string(data) == "postStop\n" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the old process to be drained")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
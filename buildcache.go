@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/qrtz/livedev/env"
+)
+
+// buildKey returns the cache key of a build.
+// It is a hash of the content of the dependency files, the build command and the go environment
+func (srv *Server) buildKey(files []string, args []string, ev *env.Env) (string, error) {
+	if len(srv.goVersion) == 0 {
+		out, err := runGo(&srv.context, srv.getModule(), srv.conf.WorkingDir, "env", "GOVERSION")
+		if err != nil {
+			return "", err
+		}
+		srv.goVersion = strings.TrimSpace(string(out))
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", goTool(&srv.context), srv.goVersion)
+
+	for _, arg := range args {
+		fmt.Fprintf(h, "%s\x00", arg)
+	}
+
+	var vars []string
+	for _, v := range ev.Data() {
+		if strings.HasPrefix(v, "GO") || strings.HasPrefix(v, "CGO_") {
+			vars = append(vars, v)
+		}
+	}
+	sort.Strings(vars)
+
+	for _, v := range vars {
+		fmt.Fprintf(h, "%s\x00", v)
+	}
+
+	names := append([]string(nil), files...)
+	sort.Strings(names)
+
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(h, "%s\x00", name)
+		_, err = io.Copy(h, f)
+		f.Close()
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCertAuthority(t *testing.T) {
+	dir, err := ioutil.TempDir("", "livedev-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := loadCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CA is reused once created
+	reloaded, err := loadCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reloaded.cert.Equal(ca.cert) {
+		t.Fatal("Expected the same CA")
+	}
+
+	cert, err := reloaded.GetCertificate(&tls.ClientHelloInfo{ServerName: "App.Local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "app.local", Roots: roots}); err != nil {
+		t.Fatal(err)
+	}
+
+	if again, _ := reloaded.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.local"}); again != cert {
+		t.Fatal("Expected the cached certificate")
+	}
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cache is a content-addressed store of files limited in size.
+// The least recently used files are evicted once the limit is exceeded.
+type Cache struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// New creates a new Cache in the given directory. A maxSize of zero or less means no limit.
+func New(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Cache{dir: dir, maxSize: maxSize}, nil
+}
+
+// Dir returns the cache directory
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// Get copies the file stored under key to dst. The return value reports whether the key was found
+func (c *Cache) Get(key, dst string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src := c.path(key)
+	if _, err := os.Stat(src); err != nil {
+		return false, nil
+	}
+
+	// Replace dst atomically in case it is in use
+	tmp := dst + ".tmp"
+	if err := copyFile(tmp, src); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	// Mark the entry as recently used
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	return true, nil
+}
+
+// Put stores a copy of the file src under key
+func (c *Cache) Put(key, src string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+
+	if err := copyFile(tmp.Name(), src); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return c.trim()
+}
+
+// Clear removes all the entries from the cache
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := os.RemoveAll(c.path(e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trim evicts the least recently used entries until the cache fits in its size limit
+func (c *Cache) trim() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, e := range entries {
+		size += e.Size()
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	for i := 0; size > c.maxSize && i < len(entries); i++ {
+		if err := os.Remove(c.path(entries[i].Name())); err != nil {
+			return err
+		}
+		size -= entries[i].Size()
+	}
+
+	return nil
+}
+
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
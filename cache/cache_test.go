@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "livedev-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := New(filepath.Join(dir, "cache"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "bin")
+	dst := filepath.Join(dir, "out")
+
+	if err := ioutil.WriteFile(src, []byte("1234"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, key := range []string{"a", "b"} {
+		if err := c.Put(key, src); err != nil {
+			t.Fatal(err)
+		}
+
+		// Make the access order deterministic
+		mtime := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(c.path(key), mtime, mtime)
+	}
+
+	if found, err := c.Get("a", dst); !found || err != nil {
+		t.Fatal("Expected a to be found", err)
+	}
+
+	if err := c.Put("c", src); err != nil {
+		t.Fatal(err)
+	}
+
+	// a was used last, b is the least recently used entry
+	for key, expect := range map[string]bool{"a": true, "b": false, "c": true} {
+		if found, _ := c.Get(key, dst); found != expect {
+			t.Fatalf("%s: Expected: %v got %v", key, expect, found)
+		}
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := c.Get("a", dst); found {
+		t.Fatal("Expected empty cache")
+	}
+}
@@ -20,20 +20,108 @@ import (
 var errInvalidSyntax = errors.New("Invalid syntax")
 
 type serverConfig struct {
-	Default        bool              `json:"default"`
-	Host           string            `json:"host"`
-	Port           int               `json:"port"`
-	Bin            string            `json:"bin"`
-	Resources      resourceConfig    `json:"resources"`
-	Assets         resourceConfig    `json:"assets"`
-	Target         string            `json:"target"`
-	WorkingDir     string            `json:"workingDir"`
-	Startup        []string          `json:"startup"`
-	Builder        []string          `json:"builder"`
-	GoRoot         string            `json:"GOROOT,omitempty"`
-	GoPath         []string          `json:"GOPATH,omitempty"`
-	StartupTimeout time.Duration     `json:"startupTimeout,omitempty"`
-	Env            map[string]string `json:"env"`
+	Default          bool              `json:"default"`
+	Name             string            `json:"name,omitempty"`
+	Host             string            `json:"host"`
+	Port             int               `json:"port"`
+	Bin              string            `json:"bin"`
+	Resources        resourceConfig    `json:"resources"`
+	Sources          resourceConfig    `json:"sources"`
+	Generated        string            `json:"generated,omitempty"`
+	Assets           resourceConfig    `json:"assets"`
+	Target           string            `json:"target"`
+	Package          string            `json:"package"`
+	WorkingDir       string            `json:"workingDir"`
+	Startup          []string          `json:"startup"`
+	Builder          []string          `json:"builder"`
+	PreBuild         [][]string        `json:"preBuild,omitempty"`
+	PostBuild        [][]string        `json:"postBuild,omitempty"`
+	PreStart         [][]string        `json:"preStart,omitempty"`
+	PostStop         [][]string        `json:"postStop,omitempty"`
+	GoRoot           string            `json:"GOROOT,omitempty"`
+	GoPath           []string          `json:"GOPATH,omitempty"`
+	GoOS             string            `json:"GOOS,omitempty"`
+	GoArch           string            `json:"GOARCH,omitempty"`
+	CgoEnabled       *bool             `json:"CGO_ENABLED,omitempty"`
+	Tags             []string          `json:"tags,omitempty"`
+	LDFlags          string            `json:"ldflags,omitempty"`
+	GCFlags          string            `json:"gcflags,omitempty"`
+	Race             bool              `json:"race,omitempty"`
+	TrimPath         bool              `json:"trimpath,omitempty"`
+	StartupTimeout   time.Duration     `json:"startupTimeout,omitempty"`
+	Env              map[string]string `json:"env"`
+	Test             *testConfig       `json:"test,omitempty"`
+	Vet              *vetConfig        `json:"vet,omitempty"`
+	Restart          restartConfig     `json:"restart"`
+	HealthCheck      healthCheckConfig `json:"healthCheck"`
+	BlueGreen        bool              `json:"blueGreen,omitempty"`
+	SocketActivation bool              `json:"socketActivation,omitempty"`
+	Stop             stopConfig        `json:"stop"`
+	Hold             *holdConfig       `json:"hold,omitempty"`
+	Protocol         string            `json:"protocol,omitempty"`
+	Listen           int               `json:"listen,omitempty"`
+	HTTP2            bool              `json:"http2,omitempty"`
+	DependsOn        []string          `json:"dependsOn,omitempty"`
+	RestartWithDeps  bool              `json:"restartWithDependencies,omitempty"`
+	Autostart        *bool             `json:"autostart,omitempty"`
+	Replicas         int               `json:"replicas,omitempty"`
+	Balance          string            `json:"balance,omitempty"`
+	// template is the configuration before variable substitution
+	template *serverConfig
+}
+
+// healthCheckConfig controls how a server is declared ready after it starts.
+// Durations are in milliseconds
+type healthCheckConfig struct {
+	Type     string        `json:"type,omitempty"`
+	Path     string        `json:"path,omitempty"`
+	Method   string        `json:"method,omitempty"`
+	Status   []int         `json:"status,omitempty"`
+	Body     string        `json:"body,omitempty"`
+	Pattern  string        `json:"pattern,omitempty"`
+	Service  string        `json:"service,omitempty"`
+	Interval time.Duration `json:"interval,omitempty"`
+	Timeout  time.Duration `json:"timeout,omitempty"`
+}
+
+// holdConfig enables holding the requests received during a restart.
+// MaxBody is in kilobytes and Timeout in seconds
+type holdConfig struct {
+	MaxRequests int32         `json:"maxRequests,omitempty"`
+	MaxBody     int64         `json:"maxBody,omitempty"`
+	Timeout     time.Duration `json:"timeout,omitempty"`
+}
+
+// stopConfig controls how a server process is stopped.
+// Grace is in seconds
+type stopConfig struct {
+	Signal string        `json:"signal,omitempty"`
+	Grace  time.Duration `json:"grace,omitempty"`
+}
+
+// restartConfig controls how a server is restarted when its process exits on its own.
+// Durations are in seconds
+type restartConfig struct {
+	Policy      string        `json:"policy,omitempty"`
+	MaxRestarts int           `json:"maxRestarts,omitempty"`
+	Window      time.Duration `json:"window,omitempty"`
+	Backoff     time.Duration `json:"backoff,omitempty"`
+	MaxBackoff  time.Duration `json:"maxBackoff,omitempty"`
+	History     int           `json:"history,omitempty"`
+}
+
+// testConfig enables running the tests of the changed packages
+type testConfig struct {
+	Args     []string `json:"args,omitempty"`
+	Parallel bool     `json:"parallel,omitempty"`
+	Block    bool     `json:"block,omitempty"`
+}
+
+// vetConfig enables running go vet and other analyzers on the changed packages
+type vetConfig struct {
+	Args      []string   `json:"args,omitempty"`
+	Analyzers [][]string `json:"analyzers,omitempty"`
+	Block     bool       `json:"block,omitempty"`
 }
 
 func (c *serverConfig) UnmarshalJSON(data []byte) error {
@@ -48,8 +136,142 @@ func (c *serverConfig) UnmarshalJSON(data []byte) error {
 		conf.Host = "localhost"
 	}
 
+	switch conf.Protocol {
+	case "":
+		conf.Protocol = protocolHTTP
+	case protocolHTTP, protocolH2C:
+	case protocolTCP:
+		if conf.Listen == 0 {
+			return errors.New("Missing listen port for the tcp protocol")
+		}
+	default:
+		return fmt.Errorf("Invalid protocol %q", conf.Protocol)
+	}
+
+	if conf.Protocol != protocolHTTP && conf.HealthCheck.Type == "" {
+		// The default HTTP/1 check does not apply
+		conf.HealthCheck.Type = healthCheckTCP
+	}
+
+	if hc := &conf.HealthCheck; len(hc.Type) > 0 || len(hc.Path) > 0 || len(hc.Method) > 0 || len(hc.Status) > 0 || len(hc.Body) > 0 || len(hc.Service) > 0 {
+		switch hc.Type {
+		case "":
+			hc.Type = healthCheckHTTP
+		case healthCheckHTTP, healthCheckTCP, healthCheckGRPC:
+		case healthCheckLog:
+			if len(hc.Pattern) == 0 {
+				return errors.New("Missing health check pattern")
+			}
+		default:
+			return fmt.Errorf("Invalid health check type %q", hc.Type)
+		}
+
+		if len(hc.Path) == 0 {
+			hc.Path = "/"
+		}
+
+		if len(hc.Method) == 0 {
+			hc.Method = "GET"
+		}
+
+		switch len(hc.Status) {
+		case 0:
+			hc.Status = []int{200, 399}
+		case 1:
+			hc.Status = append(hc.Status, hc.Status[0])
+		}
+
+		if hc.Timeout == 0 {
+			hc.Timeout = 1000
+		}
+	}
+
+	if conf.SocketActivation && conf.HealthCheck.Type == healthCheckTCP {
+		// The listening socket accepts the connections before the process is ready
+		return errors.New("The tcp health check does not apply to socket activation")
+	}
+
+	if conf.HealthCheck.Interval == 0 {
+		conf.HealthCheck.Interval = 100
+	}
+
+	switch conf.Restart.Policy {
+	case "", restartNever, restartOnFailure, restartAlways:
+	default:
+		return fmt.Errorf("Invalid restart policy %q", conf.Restart.Policy)
+	}
+
+	if conf.Restart.MaxRestarts == 0 {
+		conf.Restart.MaxRestarts = 5
+	}
+
+	if conf.Restart.Window == 0 {
+		conf.Restart.Window = 60
+	}
+
+	if conf.Restart.Backoff == 0 {
+		conf.Restart.Backoff = 1
+	}
+
+	if conf.Restart.MaxBackoff == 0 {
+		conf.Restart.MaxBackoff = 30
+	}
+
+	if conf.Restart.History == 0 {
+		conf.Restart.History = 3
+	}
+
+	conf.Stop.Signal = strings.ToUpper(strings.TrimSpace(conf.Stop.Signal))
+
+	if len(conf.Stop.Signal) == 0 {
+		conf.Stop.Signal = "SIGTERM"
+	}
+
+	if _, ok := stopSignals[conf.Stop.Signal]; !ok {
+		return fmt.Errorf("Invalid stop signal %q", conf.Stop.Signal)
+	}
+
+	if conf.Stop.Grace == 0 {
+		conf.Stop.Grace = 5
+	}
+
+	if h := conf.Hold; h != nil {
+		if h.MaxRequests == 0 {
+			h.MaxRequests = 100
+		}
+
+		if h.MaxBody == 0 {
+			h.MaxBody = 1024
+		}
+
+		if h.Timeout == 0 {
+			h.Timeout = 60
+		}
+	}
+
+	if conf.Replicas < 0 {
+		return fmt.Errorf("Invalid number of replicas %d", conf.Replicas)
+	}
+
+	if conf.Replicas == 0 {
+		conf.Replicas = 1
+	}
+
+	switch conf.Balance {
+	case "":
+		conf.Balance = balanceRoundRobin
+	case balanceRoundRobin, balanceLeastConnections, balanceSticky:
+	default:
+		return fmt.Errorf("Invalid balance strategy %q", conf.Balance)
+	}
+
+	if conf.Replicas > 1 && conf.SocketActivation {
+		return errors.New("Socket activation does not support replicas")
+	}
+
 	conf.Bin = strings.TrimSpace(conf.Bin)
 	conf.Target = strings.TrimSpace(conf.Target)
+	conf.Package = strings.TrimSpace(conf.Package)
 	conf.WorkingDir = strings.TrimSpace(conf.WorkingDir)
 
 	if len(conf.WorkingDir) == 0 {
@@ -67,24 +289,155 @@ func (c *serverConfig) UnmarshalJSON(data []byte) error {
 	}
 
 	if len(conf.Bin) == 0 {
-		conf.Bin = filepath.Join(os.TempDir(), fmt.Sprintf("livedev-%s-%d", conf.Host, conf.Port))
+		// Host patterns may contain characters that are not valid in file names
+		name := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' {
+				return r
+			}
+			return '_'
+		}, conf.Host)
+
+		conf.Bin = filepath.Join(os.TempDir(), fmt.Sprintf("livedev-%s-%d", name, conf.Port))
 	}
 
 	*c = serverConfig(conf)
 	return nil
 }
 
+// buildFlags returns the flags passed to the default builder
+func (c *serverConfig) buildFlags() []string {
+	var flags []string
+
+	if len(c.Tags) > 0 {
+		flags = append(flags, "-tags", strings.Join(c.Tags, ","))
+	}
+
+	if len(c.LDFlags) > 0 {
+		flags = append(flags, "-ldflags", c.LDFlags)
+	}
+
+	if len(c.GCFlags) > 0 {
+		flags = append(flags, "-gcflags", c.GCFlags)
+	}
+
+	if c.Race {
+		flags = append(flags, "-race")
+	}
+
+	if c.TrimPath {
+		flags = append(flags, "-trimpath")
+	}
+
+	return flags
+}
+
+// withPort returns a copy of the configuration bound to the given port.
+// References to the port are substituted again from the original configuration
+func (c *serverConfig) withPort(port int) (*serverConfig, error) {
+	conf := *c
+	if c.template != nil {
+		conf = *c.template
+	}
+
+	conf.Port = port
+	if err := processConfig(&conf, env.New(os.Environ()), '`'); err != nil {
+		return nil, err
+	}
+
+	conf.template = c.template
+	return &conf, nil
+}
+
+// startOrder returns the server hosts sorted so that every server comes after the servers it depends on.
+// Unknown dependencies and cycles are errors
+func startOrder(servers []serverConfig) ([]string, error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	var (
+		order []string
+		visit func(host string, path []string) error
+		state = make(map[string]int)
+		index = make(map[string]*serverConfig)
+	)
+
+	for i := range servers {
+		index[servers[i].Host] = &servers[i]
+	}
+
+	visit = func(host string, path []string) error {
+		switch state[host] {
+		case visited:
+			return nil
+		case visiting:
+			for i, h := range path {
+				if h == host {
+					path = path[i:]
+					break
+				}
+			}
+			return fmt.Errorf("Dependency cycle: %s -> %s", strings.Join(path, " -> "), host)
+		}
+
+		state[host] = visiting
+		path = append(path, host)
+
+		for _, dep := range index[host].DependsOn {
+			if _, ok := index[dep]; !ok {
+				return fmt.Errorf("Unknown dependency %q of server %q", dep, host)
+			}
+
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+
+		state[host] = visited
+		order = append(order, host)
+		return nil
+	}
+
+	for _, s := range servers {
+		if err := visit(s.Host, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
 type resourceConfig struct {
 	Ignore string   `json:"ignore"`
 	Paths  []string `json:"paths"`
 }
 
+type cacheConfig struct {
+	Disabled bool   `json:"disabled"`
+	Dir      string `json:"dir"`
+	MaxSize  int64  `json:"maxSize"` // in megabytes
+}
+
+// tlsConfig enables the HTTPS listener of the proxy.
+// Dir is where the development CA is stored
+type tlsConfig struct {
+	Port int    `json:"port,omitempty"`
+	Dir  string `json:"dir,omitempty"`
+}
+
 type config struct {
 	Port           int            `json:"port,omitempty"` //proxy port
 	GoRoot         string         `json:"GOROOT,omitempty"`
 	GoPath         []string       `json:"GOPATH"`
 	Servers        []serverConfig `json:"server"`
+	Processes      []serverConfig `json:"process,omitempty"`
 	StartupTimeout time.Duration  `json:"startupTimeout,omitempty"`
+	Cache          cacheConfig    `json:"cache"`
+	MaxBuilds      int            `json:"maxBuilds,omitempty"`
+	Autostart      bool           `json:"autostart,omitempty"`
+	TLS            tlsConfig      `json:"tls"`
+	Routes         []routeConfig  `json:"routes,omitempty"`
 }
 
 func (c *config) UnmarshalJSON(data []byte) error {
@@ -103,6 +456,10 @@ func (c *config) UnmarshalJSON(data []byte) error {
 		conf.StartupTimeout = c.StartupTimeout
 	}
 
+	if conf.MaxBuilds == 0 {
+		conf.MaxBuilds = c.MaxBuilds
+	}
+
 	conf.GoRoot = strings.TrimSpace(conf.GoRoot)
 
 	if len(conf.GoRoot) == 0 {
@@ -113,30 +470,99 @@ func (c *config) UnmarshalJSON(data []byte) error {
 		conf.GoPath = c.GoPath
 	}
 
-	for i := range conf.Servers {
-		s := &conf.Servers[i]
-		if len(s.GoPath) == 0 {
-			s.GoPath = conf.GoPath
+	conf.Cache.Dir = strings.TrimSpace(conf.Cache.Dir)
+
+	if len(conf.Cache.Dir) == 0 {
+		conf.Cache.Dir = c.Cache.Dir
+	}
+
+	if conf.Cache.MaxSize == 0 {
+		conf.Cache.MaxSize = c.Cache.MaxSize
+	}
+
+	if conf.TLS.Port == 0 {
+		conf.TLS.Port = c.TLS.Port
+	}
+
+	conf.TLS.Dir = strings.TrimSpace(conf.TLS.Dir)
+
+	if len(conf.TLS.Dir) == 0 {
+		conf.TLS.Dir = c.TLS.Dir
+	}
+
+	for i := range conf.Processes {
+		s := &conf.Processes[i]
+		if len(s.Name) == 0 {
+			return errors.New("Missing process name")
+		}
+		// Processes are identified by their name
+		s.Host = s.Name
+
+		if s.Replicas > 1 {
+			return fmt.Errorf("Process %q can not have replicas", s.Name)
 		}
+	}
 
-		if len(s.GoRoot) == 0 {
-			s.GoRoot = conf.GoRoot
+	for _, list := range [][]serverConfig{conf.Servers, conf.Processes} {
+		for i := range list {
+			s := &list[i]
+			if len(s.GoPath) == 0 {
+				s.GoPath = conf.GoPath
+			}
+
+			if len(s.GoRoot) == 0 {
+				s.GoRoot = conf.GoRoot
+			}
+
+			if s.StartupTimeout == 0 {
+				s.StartupTimeout = conf.StartupTimeout
+			}
+
+			if s.Autostart == nil {
+				autostart := conf.Autostart
+				s.Autostart = &autostart
+			}
+
+			template := *s
+			if err := processConfig(s, env.New(os.Environ()), '`'); err != nil {
+				return err
+			}
+			s.template = &template
 		}
+	}
 
-		if s.StartupTimeout == 0 {
-			s.StartupTimeout = conf.StartupTimeout
+	if _, err := startOrder((*config)(&conf).all()); err != nil {
+		return err
+	}
+
+	for _, rc := range conf.Routes {
+		if !strings.HasPrefix(rc.Path, "/") {
+			return fmt.Errorf("Invalid route path %q", rc.Path)
 		}
 
-		if err := processConfig(s, env.New(os.Environ()), '`'); err != nil {
+		if _, _, err := newHostMatcher([]*Server{{host: rc.Host}}); len(rc.Host) > 0 && err != nil {
 			return err
 		}
 
+		found := false
+		for _, s := range conf.Servers {
+			found = found || (s.Host == rc.Server && s.Protocol != protocolTCP)
+		}
+
+		if !found {
+			return fmt.Errorf("Unknown server %q of route %q", rc.Server, rc.Path)
+		}
 	}
 
 	*c = config(conf)
 	return nil
 }
 
+// all returns the configurations of the servers followed by those of the processes
+func (c *config) all() []serverConfig {
+	return append(append([]serverConfig{}, c.Servers...), c.Processes...)
+}
+
 func loadConfig(configFile string, conf *config) error {
 	r, err := os.Open(configFile)
 
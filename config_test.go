@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/qrtz/livedev/env"
+	"strings"
 	"testing"
 )
 
@@ -60,3 +62,100 @@ func BenchmarkProcessConfig(b *testing.B) {
 		}
 	}
 }
+
+func TestBuildFlags(t *testing.T) {
+	conf := serverConfig{
+		Tags:     []string{"dev", "sqlite"},
+		LDFlags:  "-X main.version=1.0",
+		Race:     true,
+		TrimPath: true,
+	}
+
+	expect := "-tags dev,sqlite -ldflags -X main.version=1.0 -race -trimpath"
+
+	if result := strings.Join(conf.buildFlags(), " "); result != expect {
+		t.Fatalf("Expected: %q got %q", expect, result)
+	}
+}
+
+func TestWithPort(t *testing.T) {
+	var conf config
+	input := `{"server": [{"host": "app.local", "port": 8081, "startup": ["-addr", "127.0.0.1:${port}"]}]}`
+
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &conf.Servers[0]
+	if expect := "127.0.0.1:8081"; s.Startup[1] != expect {
+		t.Fatalf("Expected: %q got %q", expect, s.Startup[1])
+	}
+
+	c, err := s.withPort(9091)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "127.0.0.1:9091"; c.Port != 9091 || c.Startup[1] != expect {
+		t.Fatalf("Expected: %q got %q", expect, c.Startup[1])
+	}
+}
+
+func TestStartOrder(t *testing.T) {
+	servers := []serverConfig{
+		{Host: "gateway", DependsOn: []string{"auth", "catalog"}},
+		{Host: "catalog", DependsOn: []string{"auth"}},
+		{Host: "auth"},
+	}
+
+	order, err := startOrder(servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "auth catalog gateway"; strings.Join(order, " ") != expect {
+		t.Fatalf("Expected: %q got %q", expect, strings.Join(order, " "))
+	}
+
+	servers[2].DependsOn = []string{"gateway"}
+
+	if _, err := startOrder(servers); err == nil || !strings.Contains(err.Error(), "auth -> gateway") {
+		t.Fatalf("Expected a cycle error got %v", err)
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	var conf config
+	input := `{"server": [{"host": "app.local", "dependsOn": ["worker"]}], "process": [{"name": "worker", "startup": ["-name", "${host}"]}]}`
+
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := conf.Processes[0]; p.Host != "worker" || p.Startup[1] != "worker" {
+		t.Fatalf("Unexpected process configuration: %q %q", p.Host, p.Startup)
+	}
+
+	if err := json.Unmarshal([]byte(`{"process": [{"startup": []}]}`), &conf); err == nil {
+		t.Fatal("Expected a missing name error")
+	}
+}
+
+func TestSocketActivationConfig(t *testing.T) {
+	var s serverConfig
+
+	for _, input := range []string{
+		`{"socketActivation": true, "protocol": "h2c"}`,
+		`{"socketActivation": true, "protocol": "tcp", "listen": 9000}`,
+		`{"socketActivation": true, "healthCheck": {"type": "tcp"}}`,
+		`{"socketActivation": true, "replicas": 2}`,
+	} {
+		if err := json.Unmarshal([]byte(input), &s); err == nil {
+			t.Fatalf("Expected %s to be rejected", input)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`{"socketActivation": true, "protocol": "h2c", "healthCheck": {"type": "grpc"}}`), &s); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,15 +3,55 @@ package main
 const (
 	envGopath = "GOPATH"
 	envGoroot = "GOROOT"
+	envGowork = "GOWORK"
+	envGoos   = "GOOS"
+	envGoarch = "GOARCH"
+
+	envCgoEnabled = "CGO_ENABLED"
+
+	envListenFds = "LISTEN_FDS"
+	envListenPid = "LISTEN_PID"
+
+	// envReplica is the replica number given to each process of a server with replicas
+	envReplica = "LIVEDEV_REPLICA"
+
+	// Sources of the warnings listed on the warnings page
+	warningVet  = "vet"
+	warningTest = "test"
+	warningSwap = "swap"
+	warningStop = "stop"
 
 	liveReloadProtocol = "livedev"
 	liveReloadHTML     = `
 	<script type="text/javascript">
-	!function (w, c) {
+	!function (w, d, c) {
 		try{
-			(new WebSocket('ws://' + w.location.hostname + ':%d/', 'livedev')).onclose=function(){w.location.reload()}
+			var p = w.location.hostname + ':' + (w.location.protocol == 'https:' ? %[2]d : %[1]d) + w.location.pathname;
+			// The page path routes the socket to the server of the page
+			var s = new WebSocket((w.location.protocol == 'https:' ? 'wss://' : 'ws://') + p, 'livedev');
+			s.onclose=function(){w.location.reload()};
+			s.onmessage=function(e){
+				var m = JSON.parse(e.data), b = d.getElementById('livedev-' + m.type);
+				if (!b) {
+					b = d.createElement('a');
+					b.id = 'livedev-' + m.type;
+					b.className = 'livedev-status';
+					b.style.cssText = 'position:fixed;right:8px;z-index:2147483647;padding:2px 8px;border-radius:3px;font:12px monospace;color:#fff;text-decoration:none';
+					b.style.bottom = (8 + 24 * d.querySelectorAll('.livedev-status').length) + 'px';
+					d.body.appendChild(b);
+				}
+				b.style.background = {running:'#888', passed:'#2a2', failed:'#d22', warning:'#e90'}[m.status];
+				b.textContent = m.type + ' ' + m.status;
+				b.title = m.output || m.packages.join('\n');
+				if (m.url) {
+					// The code viewer only listens on plain HTTP
+					b.href = m.url.charAt(0) == ':' ? 'http://' + w.location.hostname + m.url : m.url;
+				} else {
+					b.removeAttribute('href');
+				}
+			}
 		}catch(ex){c.log('Livedev: ', ex)}
-	}(window, window.console||{log:function(){}})
+	}(window, document, window.console||{log:function(){}})
     </script>
 	`
 )
@@ -27,28 +27,36 @@ func (p *pkg) Import() (*build.Package, error) {
 	return p.Context.ImportDir(p.Dir, build.AllowBinary)
 }
 
-// computeDep returns the list of the target's dependency files
-func computeDep(context *build.Context, target string) ([]string, error) {
+// computeDep returns the list of the target's dependency files along with the test files of the dependency packages.
+// When importPath is set, target is the directory from which the package is resolved.
+// mod is the module that contains the target or nil when building in GOPATH mode
+func computeDep(context *build.Context, mod *module, target, importPath string) ([]string, []string, error) {
+	if mod != nil {
+		return computeModuleDep(context, mod, target, importPath)
+	}
+
 	var (
-		queue []*pkg
-		files []string
+		queue        []*pkg
+		files, tests []string
 	)
 
 	info, err := os.Stat(target)
 
 	if err != nil {
-		return files, err
+		return files, tests, err
 	}
 
 	visited := make(map[string]bool)
 
-	if info.IsDir() {
+	if len(importPath) > 0 {
+		queue = append(queue, newPackage(context, importPath, target))
+	} else if info.IsDir() {
 		queue = append(queue, newPackage(context, "", target))
 	} else {
 		f, err := parser.ParseFile(token.NewFileSet(), target, nil, parser.ImportsOnly)
 
 		if err != nil {
-			return files, err
+			return files, tests, err
 		}
 
 		d := newPackage(context, "", filepath.Dir(target))
@@ -85,10 +93,11 @@ func computeDep(context *build.Context, target string) ([]string, error) {
 
 			f := addPrefix(p.Dir, p.CFiles, p.CgoFiles, p.GoFiles, p.HFiles, p.SFiles, p.SysoFiles)
 			files = append(files, f...)
+			tests = append(tests, addPrefix(p.Dir, p.TestGoFiles, p.XTestGoFiles)...)
 		}
 	}
 
-	return files, nil
+	return files, tests, nil
 }
 
 // addPrefix adds prefix at beginning of each name in the list
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// fileState identifies a version of a file
+type fileState struct {
+	mtime int64
+	size  int64
+}
+
+func stateOf(info os.FileInfo) fileState {
+	return fileState{info.ModTime().UnixNano(), info.Size()}
+}
+
+// generatedFiles recognizes files written by the hook steps
+// so that their watcher events do not trigger another build.
+type generatedFiles struct {
+	mu      sync.Mutex
+	pattern *regexp.Regexp
+	// written holds the files written by the steps along with the state they left them in
+	written map[string]fileState
+}
+
+func newGeneratedFiles(pattern string) (*generatedFiles, error) {
+	g := &generatedFiles{written: make(map[string]fileState)}
+
+	if len(pattern) > 0 {
+		p, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		g.pattern = p
+	}
+
+	return g, nil
+}
+
+// Track records the files of dirs before a step runs.
+// The returned function records the files the step created or modified.
+func (g *generatedFiles) Track(dirs []string) (done func()) {
+	before := snapshot(dirs)
+
+	return func() {
+		after := snapshot(dirs)
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		for name, state := range after {
+			if prev, ok := before[name]; !ok || prev != state {
+				g.written[name] = state
+			}
+		}
+	}
+}
+
+// snapshot returns the state of the files of dirs
+func snapshot(dirs []string) map[string]fileState {
+	files := make(map[string]fileState)
+
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, info := range infos {
+			if !info.IsDir() {
+				files[filepath.Join(dir, info.Name())] = stateOf(info)
+			}
+		}
+	}
+
+	return files
+}
+
+// Match tests whether the given file matches the generated pattern
+// or is still as a step left it. A file edited since then does not match
+func (g *generatedFiles) Match(name string) bool {
+	if g.pattern != nil && g.pattern.MatchString(name) {
+		return true
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	state, ok := g.written[name]
+	if !ok {
+		return false
+	}
+
+	if state != stateOf(info) {
+		delete(g.written, name)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGeneratedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "livedev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g, err := newGeneratedFiles(`_templ\.go$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(source, []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Match(filepath.Join(dir, "page_templ.go")) == false {
+		t.Fatal("Expected pattern match")
+	}
+
+	output := filepath.Join(dir, "schema_gen.go")
+	done := g.Track([]string{dir})
+	if err := ioutil.WriteFile(output, []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done()
+
+	if g.Match(output) == false {
+		t.Fatal("Expected file written during a step to match")
+	}
+
+	if g.Match(source) {
+		t.Fatal("Expected file left untouched by a step not to match")
+	}
+
+	later := time.Now().Add(time.Second)
+	os.Chtimes(output, later, later)
+
+	if g.Match(output) {
+		t.Fatal("Expected file edited after a step not to match")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// packageDirs returns the directories of the packages that contain the given dependency files
+func packageDirs(files []string) []string {
+	var dirs []string
+	visited := make(map[string]bool)
+
+	for _, f := range files {
+		switch filepath.Base(f) {
+		case "go.mod", "go.sum", "go.work", "go.work.sum", "modules.txt":
+			continue
+		}
+
+		if filepath.Ext(f) == ".a" {
+			continue
+		}
+
+		if dir := filepath.Dir(f); !visited[dir] {
+			visited[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	sort.Strings(dirs)
+	return dirs
+}
+
+// runTests runs the tests of the given package directories and reports the result to the live reload clients
+func (srv *Server) runTests(dirs []string) error {
+	log.Printf("Testing...%s %v", srv.host, dirs)
+	srv.notifyStatus(&statusMessage{Type: "test", Status: statusRunning, Packages: dirs})
+
+	args := append([]string{"test"}, srv.conf.buildFlags()...)
+	args = append(args, srv.conf.Test.Args...)
+	args = append(args, dirs...)
+
+	var out bytes.Buffer
+	cmd := exec.Command(goTool(&srv.context), args...)
+	cmd.Env = goEnv(&srv.context, srv.getModule()).Data()
+	cmd.Dir = srv.conf.WorkingDir
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		io.Copy(srv.stdout, bytes.NewReader(out.Bytes()))
+		status := &statusMessage{Type: "test", Status: statusFailed, Packages: dirs, Output: out.String()}
+
+		if !srv.conf.Test.Block {
+			// The failures do not prevent the restart. They are listed on the warnings page
+			srv.setWarning(warningTest, out.String())
+			status.URL = srv.viewerURL()
+		}
+
+		srv.notifyStatus(status)
+		return fmt.Errorf("Tests failed: %v\n%s", err, out.String())
+	}
+
+	srv.setWarning(warningTest, "")
+	log.Printf("Tests passed...%s", srv.host)
+	srv.notifyStatus(&statusMessage{Type: "test", Status: statusPassed, Packages: dirs})
+	return nil
+}
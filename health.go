@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Health check types
+const (
+	healthCheckHTTP = "http"
+	healthCheckTCP  = "tcp"
+	healthCheckLog  = "log"
+	healthCheckGRPC = "grpc"
+)
+
+// logMatcher tests the lines written to it against a pattern
+type logMatcher struct {
+	mu      sync.Mutex
+	pattern *regexp.Regexp
+	line    []byte
+	matched bool
+}
+
+func newLogMatcher(pattern *regexp.Regexp) *logMatcher {
+	return &logMatcher{pattern: pattern}
+}
+
+func (m *logMatcher) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.matched {
+		return len(b), nil
+	}
+
+	m.line = append(m.line, b...)
+
+	for {
+		i := bytes.IndexByte(m.line, '\n')
+		if i < 0 {
+			break
+		}
+
+		if m.pattern.Match(m.line[:i]) {
+			m.matched = true
+			m.line = nil
+			break
+		}
+		m.line = m.line[i+1:]
+	}
+
+	return len(b), nil
+}
+
+// Matched reports whether a line matched the pattern
+func (m *logMatcher) Matched() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.matched
+}
+
+// readinessProbe returns a function that reports whether the process is ready to accept requests.
+// output holds the matchers of the process output used by the log health check
+func (srv *Server) readinessProbe(p *process, output []*logMatcher) func() bool {
+	conf := srv.conf.HealthCheck
+	timeout := conf.Timeout * time.Millisecond
+
+	switch conf.Type {
+	case healthCheckTCP:
+		return func() bool {
+			conn, err := net.DialTimeout("tcp", p.addr, timeout)
+			if err == nil {
+				conn.Close()
+			}
+			return err == nil
+		}
+	case healthCheckLog:
+		return func() bool {
+			for _, m := range output {
+				if m.Matched() {
+					return true
+				}
+			}
+			return false
+		}
+	case healthCheckGRPC:
+		client := &http.Client{Timeout: timeout, Transport: h2cTransport}
+		return func() bool {
+			return grpcHealthCheck(client, p.addr, conf.Service)
+		}
+	case healthCheckHTTP:
+		client := &http.Client{Timeout: timeout}
+		target := &url.URL{Host: p.addr, Scheme: "http", Path: conf.Path}
+
+		return func() bool {
+			req, err := http.NewRequest(conf.Method, target.String(), nil)
+			if err != nil {
+				return false
+			}
+
+			response, err := client.Do(req)
+			if err != nil {
+				return false
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode < conf.Status[0] || response.StatusCode > conf.Status[1] {
+				return false
+			}
+
+			if len(conf.Body) > 0 {
+				body, err := ioutil.ReadAll(response.Body)
+				return err == nil && strings.Contains(string(body), conf.Body)
+			}
+
+			return true
+		}
+	}
+
+	if timeout == 0 {
+		timeout = srv.startupTimeout * time.Second
+	}
+
+	// With socket activation, the connection is accepted on behalf of the process even if it never responds
+	client := &http.Client{Timeout: timeout}
+	target := &url.URL{Host: p.addr, Scheme: "http", Path: "/"}
+
+	return func() bool {
+		response, err := client.Head(target.String())
+		if err == nil {
+			response.Body.Close()
+			return true
+		}
+
+		// The server started successfully but the handler paniced
+		t, ok := err.(*url.Error)
+		return ok && t.Err == io.EOF
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestLogMatcher(t *testing.T) {
+	m := newLogMatcher(regexp.MustCompile(`^listening on :\d+$`))
+
+	for _, chunk := range []string{"starting\nlisten", "ing on :80", "81\n"} {
+		if m.Matched() {
+			t.Fatalf("Unexpected match before %q", chunk)
+		}
+		m.Write([]byte(chunk))
+	}
+
+	if !m.Matched() {
+		t.Fatal("Expected match")
+	}
+}
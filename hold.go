@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errHoldLimit   = errors.New("Too many requests waiting for the server to restart")
+	errHoldTimeout = errors.New("Timed out waiting for the server to restart")
+)
+
+// serveHeld serves r once the server is ready. The request waits while the server restarts
+// and is replayed if the process goes away before responding.
+func (srv *Server) serveHeld(w http.ResponseWriter, r *http.Request) error {
+	conf := srv.conf.Hold
+	timeout := time.After(conf.Timeout * time.Second)
+	maxBody := conf.MaxBody << 10
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		return err
+	}
+
+	// Larger requests still wait but are not replayed
+	replay := int64(len(body)) <= maxBody
+	if !replay {
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	}
+
+	held := false
+	defer func() {
+		if held {
+			atomic.AddInt32(&srv.held, -1)
+		}
+	}()
+
+	for {
+		var err error
+
+		select {
+		case err = <-srv.ready:
+		default:
+			// The server is restarting
+			if !held {
+				held = true
+				if atomic.AddInt32(&srv.held, 1) > conf.MaxRequests {
+					return errHoldLimit
+				}
+			}
+
+			select {
+			case err = <-srv.ready:
+			case <-timeout:
+				return errHoldTimeout
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		p := srv.pickProcess(r)
+		if p == nil {
+			return errors.New("Server not started")
+		}
+
+		if replay {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		p.acquire()
+		response, err := srv.roundTrip(p, r)
+
+		if err != nil {
+			p.release()
+
+			if replay && (!srv.hasProcess(p) || !p.available()) {
+				log.Printf("%s: replaying %s %s: %v", srv.host, r.Method, r.URL.Path, err)
+
+				select {
+				case <-timeout:
+					return errHoldTimeout
+				case <-time.After(srv.conf.HealthCheck.Interval * time.Millisecond):
+				}
+				continue
+			}
+
+			return fmt.Errorf("%s\n%s\n", err.Error(), srv.stderr.ReadAll())
+		}
+
+		defer p.release()
+		defer response.Body.Close()
+		srv.stick(w, r, p)
+
+		return srv.writeResponse(w, response)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newHoldServer(t *testing.T) *Server {
+	var conf config
+	input := `{"server": [{"host": "localhost", "bin": "/bin/sh", "healthCheck": {"interval": 10}, "hold": {"maxRequests": 1, "maxBody": 1, "timeout": 1}}]}`
+
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := newServer(build.Default, conf.Servers[0], 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srv
+}
+
+// echoProcess returns a running process backed by a server that echoes the request body
+func echoProcess(t *testing.T) *process {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Write(data)
+	}))
+	t.Cleanup(ts.Close)
+
+	p := &process{addr: ts.Listener.Addr().String(), done: make(chan error, 1)}
+	p.setState(running)
+	return p
+}
+
+// crashedProcess returns a process that no longer listens on its port
+func crashedProcess(t *testing.T) *process {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	p := &process{addr: l.Addr().String(), done: make(chan error, 1)}
+	p.setState(exited)
+	return p
+}
+
+func TestServeHeldReplay(t *testing.T) {
+	srv := newHoldServer(t)
+	srv.setProcess(crashedProcess(t))
+
+	w := httptest.NewRecorder()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.serveHeld(w, httptest.NewRequest("POST", "/", strings.NewReader("hello")))
+	}()
+
+	// The request waits for the restart
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&srv.held) != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the request to be held")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case srv.ready <- nil:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// The restarted process is not up yet. The request is replayed once it is
+	time.Sleep(50 * time.Millisecond)
+	srv.setProcess(echoProcess(t))
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if body := w.Body.String(); body != "hello" {
+		t.Fatalf("Expected the replayed body got %q", body)
+	}
+
+	if n := atomic.LoadInt32(&srv.held); n != 0 {
+		t.Fatalf("Expected no held requests got %d", n)
+	}
+}
+
+func TestServeHeldLimits(t *testing.T) {
+	srv := newHoldServer(t)
+	srv.setProcess(echoProcess(t))
+
+	// The server is restarting and already holds the maximum number of requests
+	atomic.StoreInt32(&srv.held, 1)
+	if err := srv.serveHeld(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err != errHoldLimit {
+		t.Fatalf("Expected %v got %v", errHoldLimit, err)
+	}
+	atomic.StoreInt32(&srv.held, 0)
+
+	large := strings.Repeat("x", 2<<10)
+
+	// Bodies over the limit are still forwarded whole
+	srv.ready <- nil
+	w := httptest.NewRecorder()
+	if err := srv.serveHeld(w, httptest.NewRequest("POST", "/", strings.NewReader(large))); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Body.String() != large {
+		t.Fatalf("Expected the body to be forwarded whole got %d bytes", w.Body.Len())
+	}
+
+	// but are not replayed
+	srv.setProcess(crashedProcess(t))
+	srv.ready <- nil
+	if err := srv.serveHeld(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(large))); err == nil || err == errHoldTimeout {
+		t.Fatalf("Expected the request to fail without replay got %v", err)
+	}
+}
+
+func TestServeHeldTimeout(t *testing.T) {
+	srv := newHoldServer(t)
+	srv.setProcess(echoProcess(t))
+
+	start := time.Now()
+	if err := srv.serveHeld(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err != errHoldTimeout {
+		t.Fatalf("Expected %v got %v", errHoldTimeout, err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("Expected the request to wait for the timeout, returned after %v", elapsed)
+	}
+
+	if n := atomic.LoadInt32(&srv.held); n != 0 {
+		t.Fatalf("Expected no held requests got %d", n)
+	}
+}
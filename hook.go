@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// runHooks runs the given commands in order with the server's environment and working directory.
+// The output of each command is streamed to the server's log.
+// It stops at the first failure and returns an error that contains the output of the failed command.
+func (srv *Server) runHooks(name string, hooks [][]string) error {
+	if len(hooks) > 0 {
+		defer srv.generated.Track(srv.watchedDirs())()
+	}
+
+	for _, h := range hooks {
+		if len(h) == 0 {
+			continue
+		}
+
+		log.Printf("%s %s: %s", srv.host, name, strings.Join(h, " "))
+
+		var out bytes.Buffer
+		cmd := exec.Command(h[0], h[1:]...)
+		cmd.Env = srv.hookEnv().Data()
+		cmd.Dir = srv.conf.WorkingDir
+		// Use the same writer for both streams so that their output is not interleaved
+		w := io.MultiWriter(srv.stdout, &out)
+		cmd.Stdout = w
+		cmd.Stderr = w
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook failed: %s: %v\n%s", name, strings.Join(h, " "), err, out.String())
+		}
+	}
+
+	return nil
+}
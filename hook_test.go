@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	dir := t.TempDir()
+	hook := func(name string) string {
+		return fmt.Sprintf(`[["/bin/sh", "-c", "echo %s >> hooks.log"]]`, name)
+	}
+
+	var conf config
+	input := fmt.Sprintf(`{"process": [{"name": "worker", "bin": "/bin/sh", "startup": ["-c", "sleep 10"], "workingDir": %q, "preBuild": %s, "postBuild": %s, "preStart": %s, "postStop": [["/bin/sh", "-c", "echo postStop >> hooks.log; exit 1"]]}]}`,
+		dir, hook("preBuild"), hook("postBuild"), hook("preStart"))
+
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := newServer(build.Default, conf.Processes[0], 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.background = true
+
+	if err := srv.build(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing postStop hook does not prevent the restart
+	old := srv.getProcess()
+	if err := srv.restartProcess(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := srv.getProcess(); p == old || !p.available() {
+		t.Fatal("Expected the process to restart")
+	}
+
+	<-srv.stopped
+	if err := srv.stop(); err == nil {
+		t.Fatal("Expected the postStop hook to fail")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "hooks.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "preBuild postBuild preStart postStop preStart postStop"
+	if got := strings.Join(strings.Fields(string(data)), " "); got != expected {
+		t.Fatalf("Expected hooks %q got %q", expected, got)
+	}
+}
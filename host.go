@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// hostMatcher finds the server of a request host.
+// Exact hosts come first, then the longest matching wildcard ("*.example.test"),
+// then the first matching regular expression ("~^api-[0-9]+\.test$")
+type hostMatcher struct {
+	exact     map[string]*Server
+	wildcards []hostPattern
+	regexps   []hostPattern
+}
+
+type hostPattern struct {
+	suffix string
+	re     *regexp.Regexp
+	srv    *Server
+}
+
+func isHostPattern(host string) bool {
+	return strings.HasPrefix(host, "*.") || strings.HasPrefix(host, "~")
+}
+
+// dialHost returns the host used to reach the processes of a server.
+// Patterns can not be dialed
+func dialHost(host string) string {
+	if isHostPattern(host) {
+		return "localhost"
+	}
+	return host
+}
+
+// processHost returns the host the processes of the server listen on.
+// Background processes are identified by a name that may not resolve
+func (srv *Server) processHost() string {
+	if srv.background {
+		return "localhost"
+	}
+	return dialHost(srv.host)
+}
+
+// newHostMatcher returns a matcher for the given servers, listed in configuration order.
+// It fails on invalid patterns and on hosts that overlap, and reports the patterns that are shadowed by others
+func newHostMatcher(servers []*Server) (m *hostMatcher, warnings []string, err error) {
+	m = &hostMatcher{exact: make(map[string]*Server)}
+
+	for _, srv := range servers {
+		host := strings.ToLower(srv.host)
+
+		switch {
+		case strings.HasPrefix(host, "~"):
+			// Hosts are matched in lower case
+			re, err := regexp.Compile(`(?i)^(?:` + srv.host[1:] + `)$`)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid host pattern %q: %v", srv.host, err)
+			}
+			m.regexps = append(m.regexps, hostPattern{re: re, srv: srv})
+		case strings.HasPrefix(host, "*."):
+			if strings.Contains(host[2:], "*") || len(host) == 2 {
+				return nil, nil, fmt.Errorf("Invalid host pattern %q", srv.host)
+			}
+
+			for _, w := range m.wildcards {
+				if w.suffix == host[1:] {
+					return nil, nil, fmt.Errorf("Host pattern %q overlaps %q", srv.host, w.srv.host)
+				}
+			}
+			m.wildcards = append(m.wildcards, hostPattern{suffix: host[1:], srv: srv})
+		case strings.Contains(host, "*"):
+			return nil, nil, fmt.Errorf("Invalid host pattern %q: the wildcard must be the first label", srv.host)
+		default:
+			if other, ok := m.exact[host]; ok {
+				return nil, nil, fmt.Errorf("Host %q overlaps %q", srv.host, other.host)
+			}
+			m.exact[host] = srv
+		}
+	}
+
+	sort.SliceStable(m.wildcards, func(i, j int) bool {
+		return len(m.wildcards[i].suffix) > len(m.wildcards[j].suffix)
+	})
+
+	// A regular expression never receives the hosts matched by an exact host or a wildcard
+	for _, r := range m.regexps {
+		for host := range m.exact {
+			if r.re.MatchString(host) {
+				warnings = append(warnings, fmt.Sprintf("Host %q is served by %q instead of %q", host, host, r.srv.host))
+			}
+		}
+
+		for _, w := range m.wildcards {
+			if sample := "any" + w.suffix; r.re.MatchString(sample) {
+				warnings = append(warnings, fmt.Sprintf("Hosts such as %q are served by %q instead of %q", sample, w.srv.host, r.srv.host))
+			}
+		}
+	}
+
+	return m, warnings, nil
+}
+
+// match returns the server of host or nil
+func (m *hostMatcher) match(host string) *Server {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if srv, ok := m.exact[host]; ok {
+		return srv
+	}
+
+	for _, w := range m.wildcards {
+		if strings.HasSuffix(host, w.suffix) {
+			return w.srv
+		}
+	}
+
+	for _, r := range m.regexps {
+		if r.re.MatchString(host) {
+			return r.srv
+		}
+	}
+
+	return nil
+}
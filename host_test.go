@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestHostMatcher(t *testing.T) {
+	var servers []*Server
+	for _, host := range []string{"~^API-[0-9]+\\.dev$", "*.test", "*.tenant.test", "app.tenant.test", "~.*\\.local", "db.local"} {
+		servers = append(servers, &Server{host: host})
+	}
+
+	m, warnings, err := newHostMatcher(servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		host   string
+		expect string
+	}{
+		{"app.tenant.test", "app.tenant.test"},
+		{"acme.tenant.test", "*.tenant.test"},
+		{"a.b.tenant.test", "*.tenant.test"},
+		{"www.test", "*.test"},
+		{"api-1.dev", "~^API-[0-9]+\\.dev$"},
+		{"Api-2.dev", "~^API-[0-9]+\\.dev$"},
+		{"API-1.test", "*.test"},
+		{"dev.local", "~.*\\.local"},
+		{"db.local", "db.local"},
+		{"test", ""},
+	} {
+		var host string
+		if srv := m.match(test.host); srv != nil {
+			host = srv.host
+		}
+
+		if host != test.expect {
+			t.Fatalf("%s: Expected %q got %q", test.host, test.expect, host)
+		}
+	}
+
+	// "db.local" is never served by the "~.*\.local" pattern
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning got %q", warnings)
+	}
+
+	if _, _, err := newHostMatcher([]*Server{{host: "app.*.test"}}); err == nil {
+		t.Fatal("Expected an invalid pattern error")
+	}
+
+	if _, _, err := newHostMatcher([]*Server{{host: "*.test"}, {host: "*.TEST"}}); err == nil {
+		t.Fatal("Expected an overlapping pattern error")
+	}
+}
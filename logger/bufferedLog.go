@@ -50,3 +50,10 @@ func (l *BufferedLogWriter) ReadAll() string {
 	defer l.mu.Unlock()
 	return l.readAll()
 }
+
+// String returns the content of the buffer without consuming it
+func (l *BufferedLogWriter) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
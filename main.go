@@ -11,7 +11,10 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/qrtz/livedev/cache"
 	"github.com/qrtz/livedev/watcher"
 )
 
@@ -19,29 +22,105 @@ const (
 	version = "0.2.1"
 )
 
+// autostart builds and starts the given servers concurrently and reports their progress
+func autostart(servers []*Server) {
+	if len(servers) == 0 {
+		return
+	}
+
+	log.Printf("Starting %d servers\n", len(servers))
+	var done int32
+
+	for _, srv := range servers {
+		go func(srv *Server) {
+			start := time.Now()
+			srv.runOnce()
+			err := <-srv.ready
+			n := atomic.AddInt32(&done, 1)
+
+			if err != nil {
+				log.Printf("[%d/%d] %s failed to start after %v\n%v", n, len(servers), srv.host, time.Since(start), err)
+			} else {
+				log.Printf("[%d/%d] %s ready in %v\n", n, len(servers), srv.host, time.Since(start))
+			}
+		}(srv)
+	}
+}
+
+// defaultCADir returns the default location of the development CA
+func defaultCADir() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "livedev")
+	}
+	return filepath.Join(os.TempDir(), "livedev-ca")
+}
+
+// defaultCacheDir returns the default location of the build cache
+func defaultCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "livedev")
+	}
+	return filepath.Join(os.TempDir(), "livedev-cache")
+}
+
 func main() {
 	log.Printf("Livedev %s\n", version)
 
 	configFile := flag.String("c", "", "Configuration file")
+	clearCache := flag.Bool("clearcache", false, "Clear the build cache")
 	log.SetOutput(os.Stderr)
 	flag.Parse()
 
-	if len(*configFile) == 0 {
-		flag.Usage()
-		return
-	}
-
 	conf := config{
 		Port:           80,
 		GoRoot:         os.Getenv(envGoroot),
 		GoPath:         filepath.SplitList(os.Getenv(envGopath)),
 		StartupTimeout: 10, // Default startup timeout in seconds
+		MaxBuilds:      1,
+		Cache: cacheConfig{
+			Dir:     defaultCacheDir(),
+			MaxSize: 1024,
+		},
+		TLS: tlsConfig{
+			Dir: defaultCADir(),
+		},
+	}
+
+	if len(*configFile) == 0 {
+		if *clearCache {
+			if err := os.RemoveAll(conf.Cache.Dir); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("Build cache cleared:", conf.Cache.Dir)
+			return
+		}
+
+		flag.Usage()
+		return
 	}
 
 	if err := loadConfig(*configFile, &conf); err != nil {
 		log.Fatal(err)
 	}
 
+	var buildCache *cache.Cache
+
+	if !conf.Cache.Disabled {
+		c, err := cache.New(conf.Cache.Dir, conf.Cache.MaxSize<<20)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if *clearCache {
+			if err := c.Clear(); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("Build cache cleared:", c.Dir())
+		}
+
+		buildCache = c
+	}
+
 	if err := os.Setenv(envGoroot, conf.GoRoot); err != nil {
 		log.Fatal(err)
 	}
@@ -59,37 +138,121 @@ func main() {
 
 	var (
 		servers       = make(map[string]*Server)
+		ordered       []*Server
+		processes     []*Server
 		defaultServer *Server
+		// all holds both the servers and the processes
+		all = make(map[string]*Server)
 	)
 
-	for _, s := range conf.Servers {
+	create := func(s serverConfig) *Server {
 		context := build.Default
 
 		context.GOROOT = s.GoRoot
 
 		context.GOPATH = strings.Join(s.GoPath, string(filepath.ListSeparator))
+		context.BuildTags = s.Tags
+
+		if len(s.GoOS) > 0 {
+			context.GOOS = s.GoOS
+		}
+
+		if len(s.GoArch) > 0 {
+			context.GOARCH = s.GoArch
+		}
+
+		if s.CgoEnabled != nil {
+			context.CgoEnabled = *s.CgoEnabled
+		} else if context.GOOS != build.Default.GOOS || context.GOARCH != build.Default.GOARCH {
+			// Like the go command, cross builds do not use cgo unless asked to
+			context.CgoEnabled = false
+		}
 
-		if _, dup := servers[s.Host]; dup {
+		if _, dup := all[s.Host]; dup {
 			log.Fatalf(`Fatal error: Duplicate server name "%s"`, s.Host)
 		}
 
-		srv, err := newServer(context, s, conf.Port, w)
+		srv, err := newServer(context, s, conf.Port, w, buildCache)
 
 		if err != nil {
 			log.Fatalf(`Fatal error: Server binary not found "%s" : %v`, s.Host, err)
 		}
 
+		srv.tlsPort = conf.TLS.Port
+		all[s.Host] = srv
+		return srv
+	}
+
+	for _, s := range conf.Processes {
+		srv := create(s)
+		srv.background = true
+		processes = append(processes, srv)
+		log.Printf("Process: %s\n", srv.host)
+	}
+
+	for _, s := range conf.Servers {
+		srv := create(s)
 		servers[s.Host] = srv
+		ordered = append(ordered, srv)
 		log.Printf("Host: %s\n", net.JoinHostPort(srv.host, strconv.Itoa(srv.port)))
 
+		if s.Protocol == protocolTCP {
+			// Raw tcp servers are reached through their own listen port
+			log.Printf("Listen: %s\n", net.JoinHostPort("localhost", strconv.Itoa(s.Listen)))
+			continue
+		}
+
 		if defaultServer == nil || s.Default {
 			defaultServer = srv
 		}
 	}
 
-	p := newProxy(conf.Port, servers, defaultServer)
+	for _, s := range conf.all() {
+		srv := all[s.Host]
+		for _, host := range s.DependsOn {
+			dep := all[host]
+			srv.dependencies = append(srv.dependencies, dep)
+			dep.dependents = append(dep.dependents, srv)
+		}
+	}
+
+	p := newProxy(conf.Port, servers, processes, defaultServer, conf.MaxBuilds)
+	p.routes = sortRoutes(conf.Routes)
+
+	hosts, warnings, err := newHostMatcher(ordered)
+	if err != nil {
+		log.Fatalf("Fatal error: %v", err)
+	}
+
+	for _, warning := range warnings {
+		log.Println("Warning:", warning)
+	}
+	p.hosts = hosts
 	log.Printf("Proxy: %s\n", net.JoinHostPort("localhost", strconv.Itoa(conf.Port)))
 
+	if conf.TLS.Port > 0 {
+		ca, err := loadCA(conf.TLS.Dir)
+		if err != nil {
+			log.Fatalf("Fatal error: Unable to load the development CA: %v", err)
+		}
+
+		p.tlsPort = conf.TLS.Port
+		p.ca = ca
+		log.Printf("Proxy (HTTPS): %s\n", net.JoinHostPort("localhost", strconv.Itoa(conf.TLS.Port)))
+		log.Printf("Development CA: %s (add it to the trusted certificates)\n", ca.certFile)
+	}
+
+	// Processes, autostarted servers and servers that are part of a dependency graph are started at launch.
+	// Servers wait for their dependencies
+	var eager []*Server
+	order, _ := startOrder(conf.all())
+	for _, host := range order {
+		if srv := all[host]; srv.background || (srv.conf.Autostart != nil && *srv.conf.Autostart) || len(srv.dependencies) > 0 || len(srv.dependents) > 0 {
+			eager = append(eager, srv)
+		}
+	}
+	autostart(eager)
+
 	exit := make(chan os.Signal, 1)
 	signal.Notify(exit, os.Interrupt, os.Kill)
 	go func() {
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/qrtz/livedev/env"
+)
+
+// module represents the main module of a build target
+type module struct {
+	Dir   string
+	GoMod string
+	// Work is the go.work file of the workspace the module belongs to, if any
+	Work string
+	// Use lists the directories of the workspace modules
+	Use []string
+}
+
+// findUp returns the first directory, starting from path and walking up, that contains name
+func findUp(path, name string) string {
+	dir := filepath.Clean(path)
+
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	for {
+		if fileExists(filepath.Join(dir, name)) {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// findModule returns the module that contains path or nil if path is not part of a module
+func findModule(path string) *module {
+	dir := findUp(path, "go.mod")
+
+	if len(dir) == 0 {
+		return nil
+	}
+
+	m := &module{Dir: dir, GoMod: filepath.Join(dir, "go.mod")}
+
+	switch work := os.Getenv(envGowork); work {
+	case "off":
+	case "":
+		if d := findUp(dir, "go.work"); len(d) > 0 {
+			m.Work = filepath.Join(d, "go.work")
+		}
+	default:
+		m.Work = work
+	}
+
+	if len(m.Work) > 0 {
+		data, err := ioutil.ReadFile(m.Work)
+		if err != nil {
+			log.Printf("Unable to read workspace file %s: %v", m.Work, err)
+			m.Work = ""
+			return m
+		}
+
+		for _, u := range parseWorkUse(data) {
+			if !filepath.IsAbs(u) {
+				u = filepath.Join(filepath.Dir(m.Work), u)
+			}
+			m.Use = append(m.Use, filepath.Clean(u))
+		}
+	}
+
+	return m
+}
+
+// Files returns the module and workspace files that affect the build
+func (m *module) Files() []string {
+	files := []string{m.GoMod}
+
+	for _, name := range []string{"go.sum", filepath.Join("vendor", "modules.txt")} {
+		if f := filepath.Join(m.Dir, name); fileExists(f) {
+			files = append(files, f)
+		}
+	}
+
+	if len(m.Work) > 0 {
+		files = append(files, m.Work)
+		if f := m.Work + ".sum"; fileExists(f) {
+			files = append(files, f)
+		}
+	}
+
+	return files
+}
+
+// Dirs returns the root directories of the module and of its workspace modules
+func (m *module) Dirs() []string {
+	return append([]string{m.Dir}, m.Use...)
+}
+
+// parseWorkUse returns the module directories listed by the use directives of a go.work file
+func parseWorkUse(data []byte) []string {
+	var (
+		dirs  []string
+		block bool
+	)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case block && fields[0] == ")":
+			block = false
+			continue
+		case block:
+		case fields[0] != "use":
+			continue
+		case len(fields) > 1 && fields[1] == "(":
+			block = true
+			continue
+		default:
+			fields = fields[1:]
+		}
+
+		if len(fields) > 0 {
+			if dir, err := strconv.Unquote(fields[0]); err == nil {
+				dirs = append(dirs, dir)
+			} else {
+				dirs = append(dirs, fields[0])
+			}
+		}
+	}
+
+	return dirs
+}
+
+type listedModule struct {
+	Path    string
+	Version string
+	Dir     string
+	GoMod   string
+	Main    bool
+	Replace *listedModule
+}
+
+type listedPackage struct {
+	Dir          string
+	ImportPath   string
+	Standard     bool
+	Module       *listedModule
+	GoFiles      []string
+	CgoFiles     []string
+	CFiles       []string
+	CXXFiles     []string
+	HFiles       []string
+	SFiles       []string
+	SysoFiles    []string
+	EmbedFiles   []string
+	TestGoFiles  []string
+	XTestGoFiles []string
+}
+
+// goTool returns the go command for the given context
+func goTool(context *build.Context) string {
+	gobin := "go"
+
+	if len(context.GOROOT) > 0 && fileExists(context.GOROOT) {
+		gobin = filepath.Join(context.GOROOT, "bin", gobin)
+	}
+
+	return gobin
+}
+
+// goEnv returns the environment used to run the go command for the given context.
+// The workspace is set explicitly so that builds running outside of it still use it.
+func goEnv(context *build.Context, mod *module) *env.Env {
+	ev := env.New(os.Environ())
+	ev.Set(envGopath, context.GOPATH)
+	ev.Set(envGoos, context.GOOS)
+	ev.Set(envGoarch, context.GOARCH)
+
+	if context.CgoEnabled {
+		ev.Set(envCgoEnabled, "1")
+	} else {
+		ev.Set(envCgoEnabled, "0")
+	}
+
+	if mod != nil && len(mod.Work) > 0 {
+		ev.Set(envGowork, mod.Work)
+	}
+
+	return ev
+}
+
+// runGo runs the go command in dir and returns its standard output
+func runGo(context *build.Context, mod *module, dir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(goTool(context), args...)
+	cmd.Env = goEnv(context, mod).Data()
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%v\n%s", err, stderr.String())
+	}
+
+	return out, err
+}
+
+// goList returns the packages matching the given patterns along with all their dependencies.
+// Replace directives, vendor directories and workspaces are resolved by the go command.
+func goList(context *build.Context, mod *module, dir string, patterns ...string) ([]*listedPackage, error) {
+	args := []string{"list", "-e", "-deps", "-json"}
+
+	if len(context.BuildTags) > 0 {
+		args = append(args, "-tags", strings.Join(context.BuildTags, ","))
+	}
+
+	out, err := runGo(context, mod, dir, append(args, patterns...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []*listedPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+
+	for {
+		p := new(listedPackage)
+		if err := dec.Decode(p); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, p)
+	}
+
+	return pkgs, nil
+}
+
+// computeModuleDep returns the list of the target's dependency files that are not in the module cache
+// along with the test files of their packages
+func computeModuleDep(context *build.Context, mod *module, target, importPath string) ([]string, []string, error) {
+	var files, tests []string
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return files, tests, err
+	}
+
+	dir := target
+	if !info.IsDir() {
+		dir = filepath.Dir(target)
+	}
+
+	out, err := runGo(context, mod, dir, "env", "GOMODCACHE")
+	if err != nil {
+		return files, tests, err
+	}
+
+	var cache []string
+	if s := strings.TrimSpace(string(out)); len(s) > 0 {
+		cache = append(cache, s+string(filepath.Separator))
+	}
+
+	pattern := "."
+	if len(importPath) > 0 {
+		pattern = importPath
+	}
+
+	pkgs, err := goList(context, mod, dir, pattern)
+	if err != nil {
+		return files, tests, err
+	}
+
+	visited := make(map[string]bool)
+	add := func(names ...string) {
+		for _, f := range names {
+			if !visited[f] {
+				visited[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+
+	add(mod.Files()...)
+
+	for _, p := range pkgs {
+		if p.Standard || len(p.Dir) == 0 || hasPrefix(p.Dir, cache) {
+			continue
+		}
+
+		if m := p.Module; m != nil {
+			if m.Replace != nil {
+				m = m.Replace
+			}
+
+			if len(m.Dir) > 0 && !hasPrefix(m.Dir, cache) {
+				add((&module{Dir: m.Dir, GoMod: filepath.Join(m.Dir, "go.mod")}).Files()...)
+			}
+		}
+
+		add(addPrefix(p.Dir, p.CFiles, p.CXXFiles, p.CgoFiles, p.GoFiles, p.HFiles, p.SFiles, p.SysoFiles, p.EmbedFiles)...)
+		tests = append(tests, addPrefix(p.Dir, p.TestGoFiles, p.XTestGoFiles)...)
+	}
+
+	return files, tests, nil
+}
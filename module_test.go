@@ -0,0 +1,60 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var workFiles = []struct {
+	input  string
+	expect []string
+}{
+	{"go 1.21\n", nil},
+	{"go 1.21\nuse ./api\n", []string{"./api"}},
+	{"use (\n\t./api // service\n\t\"./lib\"\n\n\t../shared\n)\nuse ./web\n", []string{"./api", "./lib", "../shared", "./web"}},
+	{"// use ./commented\nreplace example.com/x => ./x\n", nil},
+}
+
+func TestParseWorkUse(t *testing.T) {
+	for _, test := range workFiles {
+		result := parseWorkUse([]byte(test.input))
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Fatalf("Expected: %q got %q", test.expect, result)
+		}
+	}
+}
+
+func TestComputeModuleDepTests(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":       "module example.com/app\n\ngo 1.21\n",
+		"main.go":      "package main\n\nfunc main() {}\n",
+		"main_test.go": "package main\n",
+		"x_test.go":    "package main_test\n",
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	context := build.Default
+	dep, tests, err := computeDep(&context, findModule(dir), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(tests, []string{filepath.Join(dir, "main_test.go"), filepath.Join(dir, "x_test.go")}) {
+		t.Fatalf("Unexpected test files %v", tests)
+	}
+
+	for _, f := range dep {
+		if filepath.Ext(f) == ".go" && f != filepath.Join(dir, "main.go") {
+			t.Fatalf("Unexpected dependency %s", f)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+var stopSignals = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGQUIT": syscall.SIGQUIT,
+}
+
+// process represents an instance of the server executable
+type process struct {
+	addr    string
+	conf    *serverConfig
+	cmd     *exec.Cmd
+	state   uint32
+	done    chan error
+	pending sync.WaitGroup
+
+	// index is the replica number of the process
+	index int
+	// active is the number of requests in progress
+	active int32
+	// retired is set once the process no longer receives new requests
+	retired int32
+}
+
+// newProcess returns a process listening on host at the port of conf
+func newProcess(host string, conf *serverConfig) *process {
+	return &process{
+		addr: net.JoinHostPort(host, strconv.Itoa(conf.Port)),
+		conf: conf,
+		done: make(chan error, 1),
+	}
+}
+
+func (p *process) setState(state processState) {
+	atomic.StoreUint32(&p.state, uint32(state))
+}
+
+func (p *process) getState() processState {
+	return processState(atomic.LoadUint32(&p.state))
+}
+
+// acquire registers a request to the process. release must be called once it completes
+func (p *process) acquire() {
+	p.pending.Add(1)
+	atomic.AddInt32(&p.active, 1)
+}
+
+func (p *process) release() {
+	atomic.AddInt32(&p.active, -1)
+	p.pending.Done()
+}
+
+func (p *process) connections() int32 {
+	return atomic.LoadInt32(&p.active)
+}
+
+func (p *process) retire() {
+	atomic.StoreInt32(&p.retired, 1)
+}
+
+// isRetired reports whether the process is being replaced or stopped
+func (p *process) isRetired() bool {
+	return atomic.LoadInt32(&p.retired) == 1
+}
+
+// available reports whether the process can receive new requests
+func (p *process) available() bool {
+	return p.getState() == running && !p.isRetired()
+}
+
+// signal sends sig to the process group
+func (p *process) signal(sig syscall.Signal) error {
+	return syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+// members returns the live processes of the process group as "pid command"
+func (p *process) members() []string {
+	var members []string
+
+	if err := syscall.Kill(-p.cmd.Process.Pid, 0); err != nil {
+		// The group is empty
+		return members
+	}
+
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	for _, name := range stats {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+
+		pid, comm, state, pgid := parseProcStat(data)
+		if pgid == p.cmd.Process.Pid && state != "Z" {
+			members = append(members, pid+" "+comm)
+		}
+	}
+
+	if len(stats) == 0 {
+		// The process table is not available
+		members = append(members, "group "+strconv.Itoa(p.cmd.Process.Pid))
+	}
+
+	return members
+}
+
+// parseProcStat returns the pid, command, state and process group of a /proc/<pid>/stat entry
+func parseProcStat(data []byte) (pid, comm, state string, pgid int) {
+	// The command is enclosed in parentheses and may contain spaces and parentheses itself
+	start, end := bytes.IndexByte(data, '('), bytes.LastIndexByte(data, ')')
+	if start < 0 || end < start {
+		return
+	}
+
+	fields := bytes.Fields(data[end+1:])
+	if len(fields) < 3 {
+		return
+	}
+
+	pid = string(bytes.TrimSpace(data[:start]))
+	comm = string(data[start+1 : end])
+	state = string(fields[0])
+	pgid, _ = strconv.Atoi(string(fields[2]))
+	return
+}
@@ -0,0 +1,12 @@
+package main
+
+import "testing"
+
+func TestParseProcStat(t *testing.T) {
+	data := []byte("4242 (my (worker) 2) S 4200 4200 4200 0 -1 4194560\n")
+
+	pid, comm, state, pgid := parseProcStat(data)
+	if pid != "4242" || comm != "my (worker) 2" || state != "S" || pgid != 4200 {
+		t.Fatalf("Unexpected result: %q %q %q %d", pid, comm, state, pgid)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Server protocols
+const (
+	protocolHTTP = "http"
+	// protocolH2C is HTTP/2 over cleartext, as used by gRPC
+	protocolH2C = "h2c"
+	protocolTCP = "tcp"
+)
+
+// gRPC status codes
+const (
+	grpcStatusOK          = 0
+	grpcStatusUnavailable = 14
+)
+
+// grpcMessageLimit is the maximum length of an error message sent to gRPC clients, before encoding
+const grpcMessageLimit = 1024
+
+// grpcServing is the SERVING status of the grpc.health.v1 service
+const grpcServing = 1
+
+var h2cTransport = newH2CTransport()
+
+// newH2CTransport returns a transport that speaks HTTP/2 over cleartext connections
+func newH2CTransport() *http.Transport {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Protocols = &protocols
+	return t
+}
+
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+// newFlushWriter returns a writer that flushes every write to the client, starting with the headers
+func newFlushWriter(w http.ResponseWriter) io.Writer {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return w
+	}
+
+	f.Flush()
+	return flushWriter{w, f}
+}
+
+func (fw flushWriter) Write(b []byte) (int, error) {
+	n, err := fw.w.Write(b)
+	fw.f.Flush()
+	return n, err
+}
+
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// writeGRPCError answers a gRPC call with an error status the client understands
+func writeGRPCError(w http.ResponseWriter, err error) {
+	wh := w.Header()
+	wh.Set("Content-Type", "application/grpc")
+	wh.Set("Grpc-Status", strconv.Itoa(grpcStatusUnavailable))
+	wh.Set("Grpc-Message", grpcEncodeMessage(err.Error()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcEncodeMessage truncates msg and percent-encodes it as the gRPC protocol requires:
+// bytes outside of the printable ASCII range, along with '%', are encoded
+func grpcEncodeMessage(msg string) string {
+	if len(msg) > grpcMessageLimit {
+		msg = msg[:grpcMessageLimit]
+	}
+
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// grpcHealthCheck reports whether the grpc.health.v1 service of the server at addr is serving
+func grpcHealthCheck(client *http.Client, addr, service string) bool {
+	req, err := http.NewRequest("POST", "http://"+addr+"/grpc.health.v1.Health/Check", bytes.NewReader(grpcHealthRequest(service)))
+	if err != nil {
+		return false
+	}
+
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false
+	}
+
+	status := resp.Trailer.Get("Grpc-Status")
+	if len(status) == 0 {
+		// Trailers-Only response
+		status = resp.Header.Get("Grpc-Status")
+	}
+
+	if status != strconv.Itoa(grpcStatusOK) || len(body) < 5 {
+		return false
+	}
+
+	return grpcServingStatus(body[5:]) == grpcServing
+}
+
+// grpcHealthRequest returns the framed HealthCheckRequest message for the given service
+func grpcHealthRequest(service string) []byte {
+	var msg []byte
+
+	if len(service) > 0 {
+		// Field 1, length delimited
+		msg = append(msg, 0x0a)
+		msg = binary.AppendUvarint(msg, uint64(len(service)))
+		msg = append(msg, service...)
+	}
+
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// grpcServingStatus returns the status field of a HealthCheckResponse message
+func grpcServingStatus(msg []byte) uint64 {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0
+		}
+		msg = msg[n:]
+
+		var value uint64
+
+		switch tag & 7 {
+		case 0:
+			value, n = binary.Uvarint(msg)
+		case 1:
+			n = 8
+		case 2:
+			var size uint64
+			size, n = binary.Uvarint(msg)
+			if n > 0 {
+				n += int(size)
+			}
+		case 5:
+			n = 4
+		default:
+			return 0
+		}
+
+		if n <= 0 || n > len(msg) {
+			return 0
+		}
+		msg = msg[n:]
+
+		if tag == 1<<3 {
+			return value
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGRPCHealthCheck(t *testing.T) {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.ProtoMajor != 2 || r.URL.Path != "/grpc.health.v1.Health/Check" || !bytes.Equal(body, grpcHealthRequest("api")) {
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte{0, 0, 0, 0, 2, 0x08, grpcServing})
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	ts.Config.Protocols = &protocols
+	ts.Start()
+	defer ts.Close()
+
+	client := &http.Client{Timeout: time.Second, Transport: h2cTransport}
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	if !grpcHealthCheck(client, addr, "api") {
+		t.Fatal("Expected serving")
+	}
+
+	if grpcHealthCheck(client, addr, "other") {
+		t.Fatal("Unexpected serving")
+	}
+}
+
+func TestGRPCServingStatus(t *testing.T) {
+	// An unknown length delimited field followed by the status
+	msg := []byte{0x12, 0x02, 'o', 'k', 0x08, 0x02}
+
+	if status := grpcServingStatus(msg); status != 2 {
+		t.Fatalf("Expected: 2 got %d", status)
+	}
+}
+
+func TestGRPCEncodeMessage(t *testing.T) {
+	if msg := grpcEncodeMessage("build failed: 100%\nmain.go:3 é"); msg != "build failed: 100%25%0Amain.go:3 %C3%A9" {
+		t.Fatalf("Unexpected message: %q", msg)
+	}
+
+	if msg := grpcEncodeMessage(strings.Repeat("a", 2*grpcMessageLimit)); len(msg) != grpcMessageLimit {
+		t.Fatalf("Expected: %d got %d", grpcMessageLimit, len(msg))
+	}
+}
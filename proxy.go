@@ -1,11 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"html/template"
 	"net"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -19,8 +21,18 @@ type proxy struct {
 	addr          *net.TCPAddr
 	port          int
 	servers       map[string]*Server
+	processes     []*Server
 	defaultServer *Server
 	codeViewerMux *serveMux
+	builds        *buildQueue
+
+	// tlsPort is the port of the HTTPS listener whose certificates are issued by ca
+	tlsPort int
+	ca      *certAuthority
+
+	// routes are evaluated before the host of the request
+	routes []routeConfig
+	hosts  *hostMatcher
 }
 
 type serveMux struct {
@@ -29,13 +41,28 @@ type serveMux struct {
 	Port    int
 }
 
-func newProxy(port int, servers map[string]*Server, defaultServer *Server) *proxy {
+// newProxy returns a proxy that routes requests to the servers.
+// The processes share the build queue and lifecycle of the servers but receive no requests.
+func newProxy(port int, servers map[string]*Server, processes []*Server, defaultServer *Server, maxBuilds int) *proxy {
 	p := &proxy{
 		port:          port,
 		servers:       servers,
+		processes:     processes,
 		defaultServer: defaultServer,
+		builds:        newBuildQueue(maxBuilds),
+	}
+
+	for _, srv := range servers {
+		srv.builds = p.builds
+	}
+
+	for _, srv := range processes {
+		srv.builds = p.builds
 	}
-	p.codeViewerMux = codeViewer(p.servers)
+
+	p.codeViewerMux = codeViewer(func(name string) *Server {
+		return p.servers[name]
+	})
 	return p
 }
 
@@ -123,7 +150,9 @@ func splitPathLine(path string) (string, int64, error) {
 	return path, -1, errors.New("No line number")
 }
 
-func codeViewer(servers map[string]*Server) *serveMux {
+// codeViewer returns the mux of the code viewer. Its paths start with the name of the server
+// (see Server.viewerPath) since the host of a request does not identify the server when routes apply.
+func codeViewer(lookup func(name string) *Server) *serveMux {
 	managerMux := &serveMux{Handler: http.NewServeMux()}
 	managerMux.Handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		var data struct {
@@ -131,21 +160,51 @@ func codeViewer(servers map[string]*Server) *serveMux {
 			Lines     []*gosource.Line
 			ErrorLine int64
 		}
-		if len(r.URL.Path) == 1 {
-			http.NotFound(w, r)
+
+		hostname, _, _ := net.SplitHostPort(r.Host)
+
+		name, rest := strings.TrimPrefix(r.URL.EscapedPath(), "/"), ""
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name, rest = name[:i], name[i+1:]
+		}
+
+		name, err := url.PathUnescape(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		hostname, _, _ := net.SplitHostPort(r.Host)
+		srv := lookup(name)
+
+		if srv == nil {
+			http.Error(w, "Server not found: "+name, http.StatusNotFound)
+			return
+		}
 
-		srv, ok := servers[hostname]
+		if len(rest) == 0 {
+			// The root page lists the warnings of the server
+			warning := srv.getWarning()
+			if len(warning) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			errTemplate.Execute(w, map[string]interface{}{
+				"Name":           "Warnings",
+				"Data":           parseError(srv.srcDirs(), net.JoinHostPort(hostname, strconv.Itoa(managerMux.Port))+srv.viewerPath(), []byte(warning)),
+				"LiveReloadHTML": template.HTML(fmt.Sprintf(liveReloadHTML, srv.proxyPort, srv.tlsPort)),
+			})
+			return
+		}
 
-		if !ok {
-			http.Error(w, "Server not found: "+hostname, http.StatusNotFound)
+		if rest, err = url.PathUnescape(rest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		path, line, err := splitPathLine(r.URL.Path[1:])
+		path, line, err := splitPathLine(rest)
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -153,7 +212,7 @@ func codeViewer(servers map[string]*Server) *serveMux {
 		}
 
 		data.ErrorLine = line
-		srcDirs := append(srv.context.SrcDirs(), srv.targetDir)
+		srcDirs := srv.srcDirs()
 
 		if dir, path := resolvePath(path, srcDirs); len(dir) > 0 {
 			filename := filepath.Join(dir, path)
@@ -186,7 +245,7 @@ func (p *proxy) handleError(w http.ResponseWriter, err ServerError, code int) {
 	templateData["Name"] = err.Name
 	templateData["Message"] = err.Message
 	templateData["Data"] = err.Data
-	templateData["LiveReloadHTML"] = template.HTML(fmt.Sprintf(liveReloadHTML, p.port))
+	templateData["LiveReloadHTML"] = template.HTML(fmt.Sprintf(liveReloadHTML, p.port, p.tlsPort))
 
 	errTemplate.Execute(w, templateData)
 }
@@ -203,8 +262,8 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			errData := ServerError{Name: "Unknown Error"}
 
 			if srv != nil {
-				addr := net.JoinHostPort(srv.host, strconv.Itoa(p.codeViewerMux.Port)) + "/"
-				errData.Data = parseError(append(srv.context.SrcDirs(), srv.targetDir), addr, buf[:runtime.Stack(buf[:], false)])
+				addr := net.JoinHostPort(host, strconv.Itoa(p.codeViewerMux.Port)) + srv.viewerPath()
+				errData.Data = parseError(srv.srcDirs(), addr, buf[:runtime.Stack(buf[:], false)])
 			}
 			p.handleError(w, errData, http.StatusInternalServerError)
 		}
@@ -214,7 +273,17 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		host = h
 	}
 
-	srv = p.servers[host]
+	if rc := matchRoute(p.routes, r, host); rc != nil {
+		srv = p.servers[rc.Server]
+		rc.apply(r)
+	} else {
+		srv = p.hosts.match(host)
+	}
+
+	if srv != nil && srv.conf.Protocol == protocolTCP {
+		// Raw tcp servers are reached through their own listen port
+		srv = nil
+	}
 
 	if srv == nil {
 		if p.defaultServer != nil {
@@ -225,14 +294,18 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	p.builds.Prioritize(srv.host)
+
 	if err := srv.ServeHTTP(w, r); err != nil {
 		if r.Header.Get("Upgrade") == "websocket" {
 			conn, buf, err := w.(http.Hijacker).Hijack()
 			writeWebSocketError(buf, err, http.StatusInternalServerError)
 			conn.Close()
+		} else if isGRPC(r) {
+			writeGRPCError(w, err)
 		} else {
 			errData := ServerError{Name: "Error"}
-			errData.Data = parseError(append(srv.context.SrcDirs(), srv.targetDir), net.JoinHostPort(srv.host, strconv.Itoa(p.codeViewerMux.Port)), []byte(err.Error()))
+			errData.Data = parseError(srv.srcDirs(), net.JoinHostPort(host, strconv.Itoa(p.codeViewerMux.Port))+srv.viewerPath(), []byte(err.Error()))
 			p.handleError(w, errData, http.StatusInternalServerError)
 		}
 	}
@@ -240,12 +313,19 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (p *proxy) shutdown() {
 	var wg sync.WaitGroup
+	shutdown := func(s *Server) {
+		defer wg.Done()
+		s.shutdown()
+	}
+
 	for _, srv := range p.servers {
 		wg.Add(1)
-		go func(s *Server) {
-			defer wg.Done()
-			s.shutdown()
-		}(srv)
+		go shutdown(srv)
+	}
+
+	for _, srv := range p.processes {
+		wg.Add(1)
+		go shutdown(srv)
 	}
 	wg.Wait()
 }
@@ -259,9 +339,44 @@ func (p *proxy) ListenAndServe() error {
 	p.addr = addr
 	done := make(chan error, 1)
 	go func() {
-		done <- http.ListenAndServe(p.addr.String(), p)
+		// Accept HTTP/2 over cleartext (h2c) along with HTTP/1
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+
+		s := &http.Server{Addr: p.addr.String(), Handler: p, Protocols: &protocols}
+		done <- s.ListenAndServe()
 	}()
 
+	if p.tlsPort > 0 {
+		go func() {
+			config := &tls.Config{
+				GetCertificate: p.ca.GetCertificate,
+				NextProtos:     []string{"h2", "http/1.1"},
+			}
+
+			l, err := tls.Listen("tcp", net.JoinHostPort("", strconv.Itoa(p.tlsPort)), config)
+			if err != nil {
+				done <- err
+				return
+			}
+
+			var protocols http.Protocols
+			protocols.SetHTTP1(true)
+			protocols.SetHTTP2(true)
+
+			done <- (&http.Server{Handler: p, Protocols: &protocols}).Serve(l)
+		}()
+	}
+
+	for _, srv := range p.servers {
+		if srv.conf.Protocol == protocolTCP {
+			go func(s *Server) {
+				done <- s.listenAndServeTCP()
+			}(srv)
+		}
+	}
+
 	select {
 	case err := <-done:
 		done <- err
@@ -269,6 +384,9 @@ func (p *proxy) ListenAndServe() error {
 		if addr, err := findAvailablePort(); err == nil {
 			go func(port int) {
 				p.codeViewerMux.Port = port
+				for _, srv := range p.servers {
+					srv.viewerPort = port
+				}
 				p.codeViewerMux.Addr = net.JoinHostPort("", strconv.Itoa(port))
 				done <- http.ListenAndServe(p.codeViewerMux.Addr, p.codeViewerMux.Handler)
 			}(addr.Port)
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCodeViewer(t *testing.T) {
+	api := &Server{host: "*.api.local", warnings: map[string]string{warningVet: "vet findings"}}
+	servers := map[string]*Server{api.host: api}
+
+	mux := codeViewer(func(name string) *Server {
+		return servers[name]
+	})
+
+	// Routed pages are served on the host of another server
+	r := httptest.NewRequest("GET", "http://localhost:9000"+api.viewerPath(), nil)
+	w := httptest.NewRecorder()
+	mux.Handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "vet findings") {
+		t.Fatalf("Unexpected response: %d %s", w.Code, w.Body.String())
+	}
+
+	r = httptest.NewRequest("GET", "http://localhost:9000/web.local/main.go:3", nil)
+	w = httptest.NewRecorder()
+	mux.Handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected: %d got %d", http.StatusNotFound, w.Code)
+	}
+}
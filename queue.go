@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+type queuedBuild struct {
+	key    string
+	host   string
+	output string
+	shared int
+	start  chan struct{}
+	done   chan struct{}
+	err    error
+}
+
+// buildQueue limits the number of concurrent builds across servers.
+// Waiting builds of the server last requested through the proxy start first.
+type buildQueue struct {
+	mu       sync.Mutex
+	limit    int
+	running  int
+	waiting  []*queuedBuild
+	priority string
+}
+
+func newBuildQueue(limit int) *buildQueue {
+	if limit < 1 {
+		limit = 1
+	}
+	return &buildQueue{limit: limit}
+}
+
+// buildID identifies a build command regardless of the output file given to the go command.
+// Commands with the same id produce the same executable.
+// Custom commands, whose output is empty, are identified by their whole environment and arguments
+func buildID(cmd *exec.Cmd, output string) string {
+	id := []string{cmd.Dir}
+
+	for _, v := range cmd.Env {
+		// The other variables do not affect the go command
+		if len(output) == 0 || strings.HasPrefix(v, "GO") || strings.HasPrefix(v, "CGO_") {
+			id = append(id, v)
+		}
+	}
+
+	for i := 0; i < len(cmd.Args); i++ {
+		if len(output) > 0 && cmd.Args[i] == "-o" && i+1 < len(cmd.Args) && cmd.Args[i+1] == output {
+			i++
+			continue
+		}
+		id = append(id, cmd.Args[i])
+	}
+
+	return strings.Join(id, "\x00")
+}
+
+// Prioritize moves the builds of the given host to the front of the queue
+func (q *buildQueue) Prioritize(host string) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	q.priority = host
+	q.mu.Unlock()
+}
+
+// Run runs fn, which builds the given output file, once a build slot is available.
+// A build that is still waiting with the same key is shared instead of running fn:
+// its output is copied to the given one.
+func (q *buildQueue) Run(key, host, output string, fn func() error) error {
+	if q == nil {
+		return fn()
+	}
+
+	q.mu.Lock()
+	for _, b := range q.waiting {
+		if b.key == key {
+			b.shared++
+			q.mu.Unlock()
+			<-b.done
+
+			if b.err == nil && len(output) > 0 && output != b.output {
+				return copyFile(output, b.output)
+			}
+			return b.err
+		}
+	}
+
+	b := &queuedBuild{
+		key:    key,
+		host:   host,
+		output: output,
+		start:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	q.waiting = append(q.waiting, b)
+	q.schedule()
+	q.mu.Unlock()
+
+	<-b.start
+	b.err = fn()
+
+	q.mu.Lock()
+	q.running--
+	q.schedule()
+	q.mu.Unlock()
+
+	close(b.done)
+	return b.err
+}
+
+// schedule starts waiting builds while slots are available. q.mu must be held
+func (q *buildQueue) schedule() {
+	for q.running < q.limit && len(q.waiting) > 0 {
+		i := 0
+		for j, b := range q.waiting {
+			if b.host == q.priority {
+				i = j
+				break
+			}
+		}
+
+		b := q.waiting[i]
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		q.running++
+		close(b.start)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitQueue(q *buildQueue, running, waiting int) {
+	for {
+		q.mu.Lock()
+		r, w := q.running, len(q.waiting)
+		q.mu.Unlock()
+		if r == running && w == waiting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBuildQueue(t *testing.T) {
+	q := newBuildQueue(1)
+	release := make(chan struct{})
+	errBuild := errors.New("build error")
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		order []string
+		calls int
+	)
+
+	run := func(key, host string, err error) {
+		defer wg.Done()
+		q.Run(key, host, "", func() error {
+			mu.Lock()
+			order = append(order, key)
+			calls++
+			mu.Unlock()
+			return err
+		})
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.Run("busy", "busy", "", func() error {
+			<-release
+			return nil
+		})
+	}()
+
+	waitQueue(q, 1, 0)
+	wg.Add(2)
+	go run("a", "a.local", nil)
+	waitQueue(q, 1, 1)
+	go run("b", "b.local", errBuild)
+	waitQueue(q, 1, 2)
+
+	wg.Add(1)
+	var shared error
+	go func() {
+		defer wg.Done()
+		shared = q.Run("b", "b.local", "", func() error {
+			t.Error("Expected the waiting build to be shared")
+			return nil
+		})
+	}()
+
+	// Let the shared build join the queue
+	for {
+		q.mu.Lock()
+		shared := q.waiting[1].shared
+		q.mu.Unlock()
+		if shared > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	q.Prioritize("b.local")
+	close(release)
+	wg.Wait()
+
+	if calls != 2 || order[0] != "b" || order[1] != "a" {
+		t.Fatalf("Expected: [b a] got %v", order)
+	}
+
+	if shared != errBuild {
+		t.Fatalf("Expected: %v got %v", errBuild, shared)
+	}
+}
+
+func TestBuildID(t *testing.T) {
+	a := exec.Command("go", "build", "-o", "/tmp/livedev-a", "-race", "./cmd/api")
+	a.Env = []string{"GOOS=linux", "HOME=/home/a"}
+	b := exec.Command("go", "build", "-o", "/tmp/livedev-b", "-race", "./cmd/api")
+	b.Env = []string{"GOOS=linux", "HOME=/home/b"}
+
+	if buildID(a, "/tmp/livedev-a") != buildID(b, "/tmp/livedev-b") {
+		t.Fatal("Expected builds of the same package to share an id")
+	}
+
+	b.Env[0] = "GOOS=darwin"
+	if buildID(a, "/tmp/livedev-a") == buildID(b, "/tmp/livedev-b") {
+		t.Fatal("Expected builds for different platforms not to share an id")
+	}
+
+	// Custom builders are opaque
+	if buildID(a, "") == buildID(b, "") {
+		t.Fatal("Expected custom builds with different outputs not to share an id")
+	}
+}
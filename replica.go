@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// Load balancing strategies of the replicas
+const (
+	balanceRoundRobin       = "round-robin"
+	balanceLeastConnections = "least-connections"
+	balanceSticky           = "sticky"
+)
+
+// replicaCookie holds the replica of a client with the sticky strategy
+const replicaCookie = "livedev_replica"
+
+// newReplicas returns the processes of the server.
+// The first replica listens on the configured port and the others on available ports
+func (srv *Server) newReplicas() ([]*process, error) {
+	conf := srv.conf
+	procs := []*process{newProcess(srv.processHost(), &conf)}
+
+	for i := 1; i < srv.conf.Replicas; i++ {
+		addr, err := findAvailablePort()
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := srv.conf.withPort(addr.Port)
+		if err != nil {
+			return nil, err
+		}
+
+		p := newProcess(srv.processHost(), c)
+		p.index = i
+		procs = append(procs, p)
+	}
+
+	return procs, nil
+}
+
+// pickProcess returns the replica that serves r according to the balance strategy.
+// r is nil for the connections that are not HTTP requests
+func (srv *Server) pickProcess(r *http.Request) *process {
+	procs := srv.getProcesses()
+
+	switch len(procs) {
+	case 0:
+		return nil
+	case 1:
+		return procs[0]
+	}
+
+	if srv.conf.Balance == balanceSticky && r != nil {
+		if c, err := r.Cookie(replicaCookie); err == nil {
+			if i, err := strconv.Atoi(c.Value); err == nil && i >= 0 && i < len(procs) && procs[i].available() {
+				return procs[i]
+			}
+		}
+	}
+
+	var pick *process
+
+	if srv.conf.Balance == balanceLeastConnections {
+		for _, p := range procs {
+			if p.available() && (pick == nil || p.connections() < pick.connections()) {
+				pick = p
+			}
+		}
+	} else {
+		// New clients of the sticky strategy are spread in turn
+		var available []*process
+		for _, p := range procs {
+			if p.available() {
+				available = append(available, p)
+			}
+		}
+
+		if len(available) > 0 {
+			pick = available[int(atomic.AddUint32(&srv.next, 1))%len(available)]
+		}
+	}
+
+	if pick == nil {
+		// None is running. Let the request fail on the first one
+		pick = procs[0]
+	}
+
+	return pick
+}
+
+// stick binds the client of r to the replica p with the sticky strategy
+func (srv *Server) stick(w http.ResponseWriter, r *http.Request, p *process) {
+	if srv.conf.Balance != balanceSticky || srv.conf.Replicas < 2 {
+		return
+	}
+
+	value := strconv.Itoa(p.index)
+	if c, err := r.Cookie(replicaCookie); err == nil && c.Value == value {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: replicaCookie, Value: value, Path: "/", HttpOnly: true})
+}
+
+// roll restarts the replicas one at a time while the others keep serving.
+// With blue/green, a replica is replaced once its successor is ready on a fresh port.
+// Otherwise it is drained and stopped before being started again on its port.
+// The rollout stops at the first replica that fails to start.
+func (srv *Server) roll() error {
+	log.Printf("Rolling restart...%s", srv.host)
+	srv.stderr.Reset()
+
+	if err := srv.runHooks("preStart", srv.conf.PreStart); err != nil {
+		return srv.swapFailed("start", err)
+	}
+
+	for _, old := range srv.getProcesses() {
+		conf := old.conf
+
+		if srv.conf.BlueGreen {
+			addr, err := findAvailablePort()
+			if err != nil {
+				return srv.swapFailed("start", err)
+			}
+
+			if conf, err = srv.conf.withPort(addr.Port); err != nil {
+				return srv.swapFailed("start", err)
+			}
+		} else {
+			srv.drain(old)
+		}
+
+		p := newProcess(srv.processHost(), conf)
+		p.index = old.index
+
+		err := srv.startProcess(p)
+		if err == nil {
+			select {
+			case err = <-p.done:
+			default:
+			}
+		}
+
+		if err != nil {
+			err = fmt.Errorf("replica %d: %v\nError:%s\n", p.index, err, srv.stderr.ReadAll())
+			if srv.conf.BlueGreen {
+				srv.stopProcess(p)
+			} else {
+				// The replica is down. The next change restarts the server
+				srv.setProcess(p)
+			}
+			return srv.swapFailed("start", err)
+		}
+
+		srv.setProcess(p)
+		log.Printf("%s: replica %d restarted on %s", srv.host, p.index, p.addr)
+
+		if srv.conf.BlueGreen {
+			go srv.drain(old)
+		}
+	}
+
+	srv.restartDependents()
+	srv.setWarning(warningSwap, "")
+	srv.notifyStatus(&statusMessage{Type: "build", Status: statusPassed, Packages: []string{}})
+	log.Println(srv.host, "...Rolling restart completed")
+	return nil
+}
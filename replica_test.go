@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPickProcess(t *testing.T) {
+	srv := &Server{conf: serverConfig{Replicas: 3, Balance: balanceRoundRobin}}
+	for i := 0; i < 3; i++ {
+		p := &process{index: i}
+		p.setState(running)
+		srv.setProcess(p)
+	}
+
+	procs := srv.getProcesses()
+	procs[1].retire()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	seen := make(map[int]int)
+	for i := 0; i < 4; i++ {
+		seen[srv.pickProcess(r).index]++
+	}
+
+	if seen[0] != 2 || seen[1] != 0 || seen[2] != 2 {
+		t.Fatalf("Unexpected round-robin distribution: %v", seen)
+	}
+
+	srv.conf.Balance = balanceLeastConnections
+	procs[0].acquire()
+	if p := srv.pickProcess(r); p.index != 2 {
+		t.Fatalf("Expected replica 2 got %d", p.index)
+	}
+	procs[0].release()
+
+	srv.conf.Balance = balanceSticky
+	r.AddCookie(&http.Cookie{Name: replicaCookie, Value: "2"})
+	for i := 0; i < 3; i++ {
+		if p := srv.pickProcess(r); p.index != 2 {
+			t.Fatalf("Expected replica 2 got %d", p.index)
+		}
+	}
+
+	// The client moves to another replica when its own is not available
+	procs[2].setState(stopping)
+	w := httptest.NewRecorder()
+	p := srv.pickProcess(r)
+	srv.stick(w, r, p)
+
+	if p.index != 0 {
+		t.Fatalf("Expected replica 0 got %d", p.index)
+	}
+
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != replicaCookie || cookies[0].Value != "0" {
+		t.Fatalf("Unexpected cookies: %v", w.Header()["Set-Cookie"])
+	}
+}
+
+func TestRestartReplica(t *testing.T) {
+	var conf config
+	input := `{"server": [{"host": "localhost", "bin": "/bin/sh", "startup": ["-c", "echo ready; sleep 10"], "replicas": 2, "startupTimeout": 5, "healthCheck": {"type": "log", "pattern": "ready"}}]}`
+
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := newServer(build.Default, conf.Servers[0], 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		for _, p := range srv.getProcesses() {
+			srv.stopProcess(p)
+		}
+	}()
+
+	procs := srv.getProcesses()
+	procs[1].signal(syscall.SIGKILL)
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if p := srv.getProcesses()[1]; p != procs[1] && p.available() {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the crashed replica to restart")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if p := srv.getProcesses()[0]; p != procs[0] || !p.available() {
+		t.Fatal("Expected the other replica to keep serving")
+	}
+}
+
+func TestRestartAllReplicas(t *testing.T) {
+	var conf config
+	input := `{"server": [{"host": "localhost", "bin": "/bin/sh", "startup": ["-c", "echo ready; sleep 10"], "replicas": 3, "startupTimeout": 5, "healthCheck": {"type": "log", "pattern": "ready"}}]}`
+
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := newServer(build.Default, conf.Servers[0], 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		for _, p := range srv.getProcesses() {
+			srv.stopProcess(p)
+		}
+	}()
+
+	// The crashes are all handled, even those reported while another replica restarts
+	procs := srv.getProcesses()
+	for _, p := range procs {
+		p.signal(syscall.SIGKILL)
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		restarted := 0
+		for i, p := range srv.getProcesses() {
+			if p != procs[i] && p.available() {
+				restarted++
+			}
+		}
+
+		if restarted == len(procs) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected all the replicas to restart, %d did", restarted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Restart policies
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+type crashRecord struct {
+	time   time.Time
+	status string
+	stderr string
+}
+
+// crashes keeps track of the recent crashes of a server process
+type crashes struct {
+	mu      sync.Mutex
+	records []crashRecord
+}
+
+// add records a crash and returns the number of crashes within the given window
+func (c *crashes) add(r crashRecord, window time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records := c.records[:0]
+	for _, rec := range c.records {
+		if r.time.Sub(rec.time) <= window {
+			records = append(records, rec)
+		}
+	}
+
+	c.records = append(records, r)
+	return len(c.records)
+}
+
+func (c *crashes) reset() {
+	c.mu.Lock()
+	c.records = nil
+	c.mu.Unlock()
+}
+
+// report returns the status and error output of the last n crashes
+func (c *crashes) report(n int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records := c.records
+	if n > 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+
+	var b bytes.Buffer
+	for _, r := range records {
+		fmt.Fprintf(&b, "%s: %s\n%s\n", r.time.Format(time.StampMilli), r.status, r.stderr)
+	}
+	return b.String()
+}
+
+// backoff returns the delay before the nth consecutive restart.
+// The first restart is immediate and the delay doubles for every following one.
+func (c *restartConfig) backoff(n int) time.Duration {
+	if n <= 1 {
+		return 0
+	}
+
+	delay := c.Backoff * time.Second
+	for i := 2; i < n && delay < c.MaxBackoff*time.Second; i++ {
+		delay *= 2
+	}
+
+	if max := c.MaxBackoff * time.Second; delay > max {
+		delay = max
+	}
+
+	return delay
+}
+
+// onExit handles a process that exited on its own according to the restart policy.
+// Only the replica of the process is restarted when the server has replicas.
+// It must be called while the server is busy.
+func (srv *Server) onExit(p *process, status error) {
+	conf := &srv.conf.Restart
+	var restart bool
+
+	switch conf.Policy {
+	case restartNever:
+	case restartAlways:
+		restart = true
+	case restartOnFailure:
+		restart = status != nil
+	default:
+		// Restart unless the process reported an error
+		restart = srv.stderr.Len() == 0 || (status != nil && strings.Contains(status.Error(), "terminated"))
+	}
+
+	if !restart {
+		if srv.conf.Replicas > 1 && srv.pickProcess(nil).available() {
+			log.Printf("%s: replica %d exited, the other replicas keep serving", srv.host, p.index)
+			return
+		}
+
+		go srv.stopAndNotify()
+		return
+	}
+
+	exitStatus := "exit status 0"
+	if status != nil {
+		exitStatus = status.Error()
+	}
+
+	n := srv.crashes.add(crashRecord{time.Now(), exitStatus, srv.stderr.String()}, conf.Window*time.Second)
+
+	if conf.MaxRestarts > 0 && n > conf.MaxRestarts {
+		log.Printf("%s is crash looping", srv.host)
+		go srv.stopWithError(fmt.Errorf("Crash loop: the process exited %d times within %v\n%s", n, conf.Window*time.Second, srv.crashes.report(conf.History)))
+		return
+	}
+
+	if srv.conf.Replicas > 1 {
+		srv.restartReplica(p, conf.backoff(n))
+		return
+	}
+
+	if delay := conf.backoff(n); delay > 0 {
+		srv.scheduleRestart(delay)
+		return
+	}
+
+	go srv.restart()
+}
+
+// scheduleRestart stops the server and starts it again once delay has elapsed.
+// The server is not busy in the meantime so that a change or a shutdown, which cancel the restart, are not delayed.
+// It must be called while the server is busy.
+func (srv *Server) scheduleRestart(delay time.Duration) {
+	// Requests wait for the restart instead of failing
+	srv.setError(srv.stop())
+	log.Printf("Restarting %s in %v", srv.host, delay)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.pendingRestart != nil {
+		srv.pendingRestart.Stop()
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		srv.busy <- true
+
+		srv.mu.Lock()
+		scheduled := srv.pendingRestart == t
+		if scheduled {
+			srv.pendingRestart = nil
+		}
+		srv.mu.Unlock()
+
+		if !scheduled {
+			// Cancelled while waiting for the server
+			<-srv.busy
+			return
+		}
+
+		defer func() {
+			srv.started <- <-srv.busy
+		}()
+		srv.restartProcess()
+	})
+	srv.pendingRestart = t
+}
+
+// cancelRestart cancels the scheduled restart, if any
+func (srv *Server) cancelRestart() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.pendingRestart != nil {
+		srv.pendingRestart.Stop()
+		srv.pendingRestart = nil
+	}
+}
+
+// restartReplica starts the replica of p again once delay has elapsed, while the other replicas keep serving.
+// Nothing is done if the server has been stopped or restarted in the meantime
+func (srv *Server) restartReplica(p *process, delay time.Duration) {
+	log.Printf("Restarting %s replica %d in %v", srv.host, p.index, delay)
+
+	time.AfterFunc(delay, func() {
+		srv.busy <- true
+		defer func() {
+			<-srv.busy
+		}()
+
+		if !srv.hasProcess(p) {
+			// Replaced by a restart of the server
+			return
+		}
+
+		// The children of the crashed replica may still hold on to its port
+		srv.stopProcess(p)
+
+		next := newProcess(srv.processHost(), p.conf)
+		next.index = p.index
+
+		err := srv.startProcess(next)
+		if err == nil {
+			select {
+			case err = <-next.done:
+			default:
+			}
+		}
+
+		// A replica that failed to start stays out of the rotation until the next restart of the server
+		srv.setProcess(next)
+
+		if err != nil {
+			log.Printf("%s: replica %d failed to restart: %v\n%s", srv.host, next.index, err, srv.stderr.ReadAll())
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	conf := restartConfig{Backoff: 1, MaxBackoff: 5}
+	expect := []time.Duration{0, 0, 1, 2, 4, 5, 5}
+
+	for n, e := range expect {
+		if d := conf.backoff(n); d != e*time.Second {
+			t.Fatalf("%d: Expected: %v got %v", n, e*time.Second, d)
+		}
+	}
+}
+
+func TestCrashes(t *testing.T) {
+	var c crashes
+	now := time.Now()
+
+	for i, expect := range []int{1, 2, 3, 2} {
+		// The last crash happens after the first two fall out of the window
+		at := now.Add(time.Duration(i*i) * time.Second)
+		if n := c.add(crashRecord{time: at, status: "exit status 2"}, 5*time.Second); n != expect {
+			t.Fatalf("%d: Expected: %d got %d", i, expect, n)
+		}
+	}
+
+	if n := strings.Count(c.report(1), "exit status 2"); n != 1 {
+		t.Fatalf("Expected: 1 report got %d", n)
+	}
+}
+
+func TestCancelRestart(t *testing.T) {
+	srv := &Server{busy: make(chan bool, 1), stopped: make(chan bool, 1)}
+	// Already stopped
+	srv.stopped <- true
+
+	// A change holds the server while the delay elapses
+	srv.busy <- true
+	srv.scheduleRestart(time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	srv.cancelRestart()
+	<-srv.busy
+
+	// The cancelled restart takes the server over and releases it
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case srv.busy <- true:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the cancelled restart to release the server")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// routeConfig routes the requests matching a path prefix and headers to a server
+type routeConfig struct {
+	Path string `json:"path"`
+	// Host restricts the route to a request host
+	Host string `json:"host,omitempty"`
+	// Headers are matched against the request headers. An empty value only requires the header to be present
+	Headers     map[string]string `json:"headers,omitempty"`
+	Server      string            `json:"server"`
+	StripPrefix bool              `json:"stripPrefix,omitempty"`
+	// Rewrite replaces the path prefix
+	Rewrite string `json:"rewrite,omitempty"`
+
+	hosts *hostMatcher
+}
+
+// sortRoutes returns the routes with the most specific first: longest path, then host and header matchers.
+// Otherwise, routes keep their order
+func sortRoutes(routes []routeConfig) []routeConfig {
+	sorted := append([]routeConfig{}, routes...)
+	for i := range sorted {
+		if rc := &sorted[i]; len(rc.Host) > 0 {
+			// Hosts are validated with the configuration
+			rc.hosts, _, _ = newHostMatcher([]*Server{{host: rc.Host}})
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := &sorted[i], &sorted[j]
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) > len(b.Path)
+		}
+
+		if (len(a.Host) > 0) != (len(b.Host) > 0) {
+			return len(a.Host) > 0
+		}
+
+		return len(a.Headers) > len(b.Headers)
+	})
+	return sorted
+}
+
+// matchRoute returns the first route matching r or nil
+func matchRoute(routes []routeConfig, r *http.Request, host string) *routeConfig {
+	for i := range routes {
+		if routes[i].match(r, host) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+func (rc *routeConfig) match(r *http.Request, host string) bool {
+	if rc.hosts != nil && rc.hosts.match(host) == nil {
+		return false
+	}
+
+	if !matchPathPrefix(rc.Path, r.URL.Path) {
+		return false
+	}
+
+	for name, value := range rc.Headers {
+		values := r.Header.Values(name)
+
+		if len(values) == 0 || (len(value) > 0 && !hasValue(values, value)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasValue reports whether one of the values of a repeated header is value
+func hasValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// apply rewrites the path of r according to the route
+func (rc *routeConfig) apply(r *http.Request) {
+	rewrite := rc.Rewrite
+
+	if len(rewrite) == 0 {
+		if !rc.StripPrefix {
+			return
+		}
+		rewrite = "/"
+	}
+
+	path := rewrite
+	if rest := strings.TrimPrefix(r.URL.Path, rc.Path); len(rest) > 0 {
+		path = strings.TrimSuffix(rewrite, "/") + "/" + strings.TrimPrefix(rest, "/")
+	}
+
+	r.Header.Set("X-Forwarded-Prefix", rc.Path)
+	r.URL.Path = path
+	r.URL.RawPath = ""
+}
+
+// matchPathPrefix reports whether path starts with prefix at a segment boundary
+func matchPathPrefix(prefix, path string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	routes := sortRoutes([]routeConfig{
+		{Path: "/", Server: "web"},
+		{Path: "/api", Server: "api", StripPrefix: true},
+		{Path: "/api", Server: "beta", Headers: map[string]string{"X-Beta": ""}},
+		{Path: "/api/v2", Server: "api2", Rewrite: "/v2"},
+	})
+
+	for _, test := range []struct {
+		path, header, server, expect string
+	}{
+		{"/", "", "web", "/"},
+		{"/apix", "", "web", "/apix"},
+		{"/api", "", "api", "/"},
+		{"/api/users", "", "api", "/users"},
+		{"/api/users", "X-Beta", "beta", "/api/users"},
+		{"/api/v2/users", "", "api2", "/v2/users"},
+	} {
+		r, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		if len(test.header) > 0 {
+			r.Header.Set(test.header, "1")
+		}
+
+		rt := matchRoute(routes, r, "localhost")
+		if rt == nil || rt.Server != test.server {
+			t.Fatalf("%s: Expected server %q got %v", test.path, test.server, rt)
+		}
+
+		rt.apply(r)
+		if r.URL.Path != test.expect {
+			t.Fatalf("%s: Expected path %q got %q", test.path, test.expect, r.URL.Path)
+		}
+	}
+}
+
+func TestRouteRepeatedHeader(t *testing.T) {
+	rc := routeConfig{Path: "/", Headers: map[string]string{"X-Tenant": "beta"}}
+
+	r, _ := http.NewRequest("GET", "http://localhost/", nil)
+	r.Header.Add("X-Tenant", "alpha")
+	r.Header.Add("X-Tenant", "beta")
+
+	if !rc.match(r, "localhost") {
+		t.Fatal("Expected any value of a repeated header to match")
+	}
+
+	r.Header.Del("X-Tenant")
+	r.Header.Add("X-Tenant", "alpha")
+	if rc.match(r, "localhost") {
+		t.Fatal("Expected the route not to match")
+	}
+}
@@ -10,21 +10,21 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
 	"compress/gzip"
 	"io/ioutil"
 
+	"github.com/qrtz/livedev/cache"
 	"github.com/qrtz/livedev/env"
 	"github.com/qrtz/livedev/logger"
 	"github.com/qrtz/livedev/watcher"
@@ -146,52 +146,121 @@ func (u *updateListeners) notify() {
 
 // Server represents an http server
 type Server struct {
-	addr           string
 	bin            string
 	builder        []string
 	closed         chan struct{}
 	context        build.Context
+	module         *module
 	dep            map[string]struct{}
+	tests          map[string]struct{}
 	host           string
 	port           int
 	resources      *resource
+	sources        *resource
 	assets         *resource
-	startup        []string
+	generated      *generatedFiles
+	healthPattern  *regexp.Regexp
 	target         string
 	targetDir      string
+	pkg            string
 	stdout         *logger.LogWriter
 	stderr         *logger.BufferedLogWriter
 	startupTimeout time.Duration
 	watcher        *watcher.Watcher
 	watcherEvents  chan watcher.Event
-	pending        sync.WaitGroup
 
+	crashes         crashes
 	updateListeners *updateListeners
+	statusListeners *messageListeners
 
 	busy    chan bool
 	ready   chan error
 	stopped chan bool
 	started chan bool
 	exit    chan bool
-	done    chan error
 
 	mu    sync.Mutex
 	error error
+	// warnings holds the latest warning of each source
+	warnings map[string]string
 
 	once sync.Once
 
-	cmd          *exec.Cmd
-	processState uint32
-	conf         serverConfig
-	proxyPort    int
+	// procs holds one process per replica. It is replaced as a whole when a replica changes
+	procs     []*process
+	conf      serverConfig
+	proxyPort int
+
+	// cache stores the binaries built by the default builder. It is nil when disabled
+	cache     *cache.Cache
+	goVersion string
+	builds    *buildQueue
+
+	// viewerPort is the port of the code viewer that displays the warnings
+	viewerPort int
+
+	// listener is the socket handed to the process when socket activation is enabled
+	listener *net.TCPListener
+
+	// held is the number of requests waiting for a restart to complete
+	held int32
+
+	// dependencies are the servers to start before this one. dependents are the servers that depend on it
+	dependencies []*Server
+	dependents   []*Server
+
+	// background is set for the processes that are supervised but not proxied
+	background bool
+
+	// tlsPort is the HTTPS port of the proxy. It is zero when HTTPS is disabled
+	tlsPort int
+
+	// pendingRestart is the timer of the restart that waits for its backoff delay
+	pendingRestart *time.Timer
+
+	// next is the round-robin counter of the replicas
+	next uint32
+}
+
+// setProcess puts p in the slot of its replica
+func (srv *Server) setProcess(p *process) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	n := len(srv.procs)
+	if p.index >= n {
+		n = p.index + 1
+	}
+
+	procs := make([]*process, n)
+	copy(procs, srv.procs)
+	procs[p.index] = p
+	srv.procs = procs
+}
+
+// getProcess returns the process of the first replica
+func (srv *Server) getProcess() *process {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if len(srv.procs) == 0 {
+		return nil
+	}
+	return srv.procs[0]
 }
 
-func (srv *Server) setProcessState(state processState) {
-	atomic.StoreUint32(&srv.processState, uint32(state))
+// getProcesses returns the processes of all the replicas
+func (srv *Server) getProcesses() []*process {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.procs
 }
 
-func (srv *Server) getProcessState() processState {
-	return processState(atomic.LoadUint32(&srv.processState))
+// hasProcess reports whether p is the current process of its replica
+func (srv *Server) hasProcess(p *process) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return p.index < len(srv.procs) && srv.procs[p.index] == p
 }
 
 func (srv *Server) setError(err error) {
@@ -206,6 +275,69 @@ func (srv *Server) getError() error {
 	return srv.error
 }
 
+// setWarning replaces the warning of the given source. An empty warning clears it
+func (srv *Server) setWarning(source, warning string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if len(warning) == 0 {
+		delete(srv.warnings, source)
+		return
+	}
+
+	if srv.warnings == nil {
+		srv.warnings = make(map[string]string)
+	}
+	srv.warnings[source] = warning
+}
+
+// addWarning appends a warning to those of the given source
+func (srv *Server) addWarning(source, warning string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if w, ok := srv.warnings[source]; ok {
+		warning = w + "\n" + warning
+	}
+
+	if srv.warnings == nil {
+		srv.warnings = make(map[string]string)
+	}
+	srv.warnings[source] = warning
+}
+
+// getWarning returns the warnings of all the sources
+func (srv *Server) getWarning() string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	var sources []string
+	for source := range srv.warnings {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	var warnings []string
+	for _, source := range sources {
+		warnings = append(warnings, srv.warnings[source])
+	}
+
+	return strings.Join(warnings, "\n")
+}
+
+// setModule records the module of the target, which is looked up again on every build
+func (srv *Server) setModule(mod *module) {
+	srv.mu.Lock()
+	srv.module = mod
+	srv.mu.Unlock()
+}
+
+func (srv *Server) getModule() *module {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.module
+}
+
 func (srv *Server) watch(path string) error {
 	return srv.watcher.Add(path, srv.watcherEvents)
 }
@@ -216,27 +348,74 @@ func (srv *Server) unwatch(path string) error {
 
 func (srv *Server) unwatchAll() error {
 	srv.resources.Walk(srv.unwatch)
+	srv.sources.Walk(srv.unwatch)
 	srv.assets.Walk(srv.unwatch)
 	for p := range srv.dep {
 		srv.unwatch(p)
 	}
+	for p := range srv.tests {
+		srv.unwatch(p)
+	}
 	return nil
 }
 
+// watchedDirs returns the directories of the files and directories being watched
+func (srv *Server) watchedDirs() []string {
+	var dirs []string
+	seen := make(map[string]bool)
+
+	add := func(path string) error {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			path = filepath.Dir(path)
+		}
+
+		if !seen[path] {
+			seen[path] = true
+			dirs = append(dirs, path)
+		}
+		return nil
+	}
+
+	srv.resources.Walk(add)
+	srv.sources.Walk(add)
+	srv.assets.Walk(add)
+	for p := range srv.dep {
+		add(p)
+	}
+
+	return dirs
+}
+
 func (srv *Server) startWatcher() {
 	var mu sync.Mutex
 	var timer *time.Timer
+	pending := make(map[string]struct{})
 	for {
 		select {
 		case event := <-srv.watcherEvents:
 			mu.Lock()
+			pending[event.Name] = struct{}{}
 			if timer != nil {
 				timer.Stop()
 				timer = nil
 			}
 
 			timer = time.AfterFunc(1*time.Second, func() {
-				srv.sync(event.Name)
+				mu.Lock()
+				var names []string
+				for name := range pending {
+					names = append(names, name)
+					delete(pending, name)
+				}
+				mu.Unlock()
+
+				if len(names) > 0 {
+					err := srv.sync(names...)
+					if err != nil && srv.background {
+						// No request reports the errors of background processes
+						log.Printf("%s: %v", srv.host, err)
+					}
+				}
 			})
 			mu.Unlock()
 		}
@@ -245,6 +424,8 @@ func (srv *Server) startWatcher() {
 
 func (srv *Server) runOnce() {
 	srv.once.Do(func() {
+		srv.waitDependencies()
+
 		srv.busy <- true
 		defer func() {
 			srv.started <- true
@@ -263,11 +444,12 @@ func (srv *Server) runOnce() {
 			}
 		}
 		srv.resources.Walk(srv.watch)
+		srv.sources.Walk(srv.watch)
 		srv.assets.Walk(srv.watch)
 	})
 }
 
-func newServer(context build.Context, conf serverConfig, proxyPort int, w *watcher.Watcher) (*Server, error) {
+func newServer(context build.Context, conf serverConfig, proxyPort int, w *watcher.Watcher, c *cache.Cache) (*Server, error) {
 	var err error
 	srv := new(Server)
 	srv.conf = conf
@@ -279,20 +461,53 @@ func newServer(context build.Context, conf serverConfig, proxyPort int, w *watch
 		return nil, err
 	}
 
+	srv.sources, err = newResource(conf.Sources.Paths, conf.Sources.Ignore)
+
+	if err != nil {
+		return nil, err
+	}
+
 	srv.assets, err = newResource(conf.Assets.Paths, conf.Assets.Ignore)
 
 	if err != nil {
 		return nil, err
 	}
 
+	srv.generated, err = newGeneratedFiles(conf.Generated)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.HealthCheck.Type == healthCheckLog {
+		srv.healthPattern, err = regexp.Compile(conf.HealthCheck.Pattern)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	srv.startupTimeout = conf.StartupTimeout
 
 	srv.target = strings.TrimSpace(conf.Target)
 	srv.targetDir = filepath.Dir(conf.Target)
+	srv.pkg = conf.Package
+
+	if len(srv.pkg) > 0 {
+		// The package is resolved from the working directory
+		srv.target = conf.WorkingDir
+		srv.targetDir = conf.WorkingDir
+
+		if build.IsLocalImport(srv.pkg) {
+			srv.targetDir = filepath.Join(conf.WorkingDir, srv.pkg)
+		}
+	}
 	srv.bin = strings.TrimSpace(conf.Bin)
 	srv.builder = conf.Builder
 
-	if !hasPrefix(srv.target, filepath.SplitList(context.GOPATH)) {
+	srv.module = findModule(srv.target)
+
+	if srv.module == nil && !hasPrefix(srv.target, filepath.SplitList(context.GOPATH)) {
 		// Target is not in the $GOPATH
 		// Try to guess the import root(workspace) from the path
 		roots := importRoots(srv.target)
@@ -305,13 +520,10 @@ func newServer(context build.Context, conf serverConfig, proxyPort int, w *watch
 	srv.context = context
 
 	if len(srv.builder) == 0 {
-		gobin := "go"
-
-		if len(context.GOROOT) > 0 && fileExists(context.GOROOT) {
-			gobin = filepath.Join(context.GOROOT, "bin", gobin)
-		}
-
-		srv.builder = append(srv.builder, gobin, "build", "-o", srv.bin)
+		srv.builder = append(srv.builder, goTool(&context), "build", "-o", srv.bin)
+		srv.builder = append(srv.builder, conf.buildFlags()...)
+		// Custom builders may depend on files we do not know about
+		srv.cache = c
 	}
 
 	srv.watcher = w
@@ -320,50 +532,41 @@ func newServer(context build.Context, conf serverConfig, proxyPort int, w *watch
 	srv.busy = make(chan bool, 1)
 	srv.stopped = make(chan bool, 1)
 	srv.started = make(chan bool, 1)
-	srv.done = make(chan error, 1)
 	srv.exit = make(chan bool, 1)
 	srv.updateListeners = newUpdateListeners()
+	srv.statusListeners = newMessageListeners()
 	srv.port = conf.Port
-	srv.startup = conf.Startup
 	srv.host = conf.Host
 	srv.stdout = logger.NewLogWriter(os.Stdout, srv.host+"> ", log.LstdFlags)
 	srv.stderr = new(logger.BufferedLogWriter)
 	return srv, nil
 }
 
-func (srv *Server) testConnection(target *url.URL, timeout time.Duration) <-chan error {
+func (srv *Server) testConnection(p *process, ready func() bool, timeout, interval time.Duration) <-chan error {
 	t := time.After(timeout)
 	done := make(chan error, 1)
-	client := &http.Client{}
 	go func() {
 		for {
 			select {
-			case err := <-srv.done:
+			case err := <-p.done:
 				done <- err
-				srv.done <- err
+				p.done <- err
+				return
 			case <-t:
 				done <- errTimeout
 				return
 			default:
-				response, err := client.Head(target.String())
-				if err == nil {
-					response.Body.Close()
+				if ready() {
 					done <- nil
 					return
 				}
 
-				if t, ok := err.(*url.Error); ok && t.Err == io.EOF {
-					// The server started successfully but the handler paniced
+				if p.getState() != running {
 					done <- nil
 					return
 				}
 
-				if srv.getProcessState() != running {
-					done <- nil
-					return
-				}
-
-				time.Sleep(100 * time.Millisecond)
+				time.Sleep(interval)
 			}
 		}
 	}()
@@ -383,13 +586,46 @@ func (srv *Server) stopAndNotify() error {
 	return err
 }
 
+// stopWithError stops the server and reports err until the next change
+func (srv *Server) stopWithError(err error) error {
+	srv.busy <- true
+	defer func() {
+		srv.updateListeners.notify()
+		srv.started <- <-srv.busy
+	}()
+
+	if stopErr := srv.stop(); stopErr != nil {
+		err = fmt.Errorf("%v\n%v", err, stopErr)
+	}
+
+	srv.setError(err)
+	return err
+}
+
 func (srv *Server) stop() error {
 	log.Printf("Stopping...%s:%v", srv.host, srv.port)
 	select {
 	case srv.stopped <- true:
+		// Report only the processes that survive this stop
+		srv.setWarning(warningStop, "")
+
 		<-time.After(10 * time.Millisecond)
-		srv.pending.Wait()
-		return srv.stopProcess()
+		procs := srv.getProcesses()
+		for _, p := range procs {
+			p.pending.Wait()
+		}
+
+		var err error
+		for _, p := range procs {
+			if stopErr := srv.stopProcess(p); err == nil {
+				err = stopErr
+			}
+		}
+
+		if hookErr := srv.runHooks("postStop", srv.conf.PostStop); err == nil {
+			err = hookErr
+		}
+		return err
 	default:
 	}
 	return nil
@@ -402,16 +638,19 @@ func (srv *Server) shutdown() error {
 		<-srv.busy
 	}()
 
+	srv.cancelRestart()
+
 	select {
 	case srv.exit <- true:
 		srv.unwatchAll()
+		defer srv.closeActivationSocket()
 		return srv.stop()
 	default:
 		return nil
 	}
 }
 
-func (srv *Server) sync(filename string) error {
+func (srv *Server) sync(filenames ...string) error {
 	srv.busy <- true
 	notifyUpdate := true
 
@@ -422,24 +661,123 @@ func (srv *Server) sync(filename string) error {
 		srv.started <- <-srv.busy
 	}()
 
-	_, rebuild := srv.dep[filename]
+	var (
+		rebuild, restart, reload, changed bool
+		changedDep, changedTests          []string
+	)
+
+	for _, filename := range filenames {
+		if srv.generated.Match(filename) {
+			// Written by our own build steps
+			continue
+		}
+
+		if _, test := srv.tests[filename]; test {
+			// Test files do not affect the server
+			changedTests = append(changedTests, filename)
+			continue
+		}
+
+		_, dep := srv.dep[filename]
+		if dep {
+			changedDep = append(changedDep, filename)
+		}
+
+		changed = true
+		rebuild = rebuild || dep || srv.sources.MatchPath(filename)
+		restart = restart || srv.resources.MatchPath(filename)
+		reload = reload || srv.assets.MatchPath(filename)
+	}
+
+	restart = restart || rebuild
+
+	var testDirs []string
+	if srv.conf.Test != nil {
+		testDirs = packageDirs(append(changedDep, changedTests...))
+	}
+
+	if !restart && len(testDirs) > 0 {
+		// Nothing is rebuilt. The result is reported when the tests complete
+		go srv.runTests(testDirs)
+	}
 
-	restart := rebuild || srv.resources.MatchPath(filename)
+	if !changed {
+		notifyUpdate = false
+		return nil
+	}
 
-	if !restart && !srv.assets.MatchPath(filename) {
+	if !restart && !reload {
 		return nil
 	}
 
 	if restart {
-		err := srv.stop()
+		// The change restarts the server anyway
+		srv.cancelRestart()
+	}
+
+	// Keep the current process serving while the new one is built and started
+	swap := restart && srv.canSwap()
+
+	var tests chan error
+
+	if restart && len(testDirs) > 0 {
+		tests = make(chan error, 1)
+		go func() {
+			tests <- srv.runTests(testDirs)
+		}()
+
+		if !srv.conf.Test.Parallel {
+			err := <-tests
+			tests <- err
+		}
+	}
+
+	if restart && !swap {
+		// A change may fix a crashing server. Give it a fresh start
+		srv.crashes.reset()
+
+		// A failing postStop hook is reported but does not prevent the rebuild
+		srv.setError(srv.stop())
+	}
+
+	if rebuild {
+		err := srv.build()
+		if swap && err != nil {
+			notifyUpdate = false
+			return srv.swapFailed("build", err)
+		}
+
 		srv.setError(err)
+
 		if err != nil {
 			return err
 		}
 	}
 
-	if rebuild {
-		err := srv.build()
+	if dirs := packageDirs(changedDep); rebuild && srv.conf.Vet != nil && len(dirs) > 0 {
+		findings := srv.runVet(dirs)
+
+		if srv.conf.Vet.Block && len(findings) > 0 {
+			err := errors.New(findings)
+			if swap {
+				notifyUpdate = false
+				return srv.swapFailed("vet", err)
+			}
+
+			srv.setError(err)
+			return err
+		}
+
+		srv.setWarning(warningVet, findings)
+	}
+
+	if tests != nil && srv.conf.Test.Block {
+		err := <-tests
+		if swap && err != nil {
+			notifyUpdate = false
+			return srv.swapFailed("test", err)
+		}
+
 		srv.setError(err)
 
 		if err != nil {
@@ -447,6 +785,22 @@ func (srv *Server) sync(filename string) error {
 		}
 	}
 
+	if swap {
+		srv.crashes.reset()
+
+		replace := srv.swap
+		if srv.conf.Replicas > 1 {
+			replace = srv.roll
+		}
+
+		if err := replace(); err != nil {
+			notifyUpdate = false
+			return err
+		}
+
+		return nil
+	}
+
 	if restart {
 		// Let start handle the notification
 		notifyUpdate = false
@@ -455,6 +809,7 @@ func (srv *Server) sync(filename string) error {
 			err = fmt.Errorf("%v\nError:%s\n", err, srv.stderr.ReadAll())
 		}
 		srv.setError(err)
+		return err
 	}
 
 	return nil
@@ -464,30 +819,72 @@ func (srv *Server) start() error {
 	log.Printf("Starting...%s", srv.host)
 	defer srv.updateListeners.notify()
 
-	if len(srv.addr) == 0 {
-		srv.addr = net.JoinHostPort(srv.host, strconv.Itoa(srv.port))
+	procs, err := srv.newReplicas()
+	if err != nil {
+		return err
 	}
 
-	log.Println(srv.addr)
+	for _, p := range procs {
+		log.Println(p.addr)
 
-	if _, err := net.ResolveTCPAddr("tcp", srv.addr); err != nil {
-		return err
+		if _, err := net.ResolveTCPAddr("tcp", p.addr); err != nil {
+			return err
+		}
 	}
 
 	srv.stderr.Reset()
 
-	err := srv.startProcess()
-	if err == nil {
-		select {
-		case err = <-srv.done:
-		default:
+	if err := srv.runHooks("preStart", srv.conf.PreStart); err != nil {
+		return err
+	}
+
+	for _, p := range procs {
+		srv.setProcess(p)
+	}
+
+	for _, p := range procs {
+		err = srv.startProcess(p)
+		if err == nil {
+			select {
+			case err = <-p.done:
+			default:
+			}
 		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		srv.restartDependents()
 	}
 
 	log.Println(srv.host, "...Startup completed")
 	return err
 }
 
+// waitDependencies starts the servers srv depends on and waits until they are ready
+func (srv *Server) waitDependencies() {
+	for _, dep := range srv.dependencies {
+		dep.runOnce()
+
+		if err := <-dep.ready; err != nil {
+			log.Printf("%s: Dependency %s is not ready: %v", srv.host, dep.host, err)
+		}
+	}
+}
+
+// restartDependents restarts the started servers that follow the restarts of srv
+func (srv *Server) restartDependents() {
+	for _, d := range srv.dependents {
+		if d.conf.RestartWithDeps && d.getProcess() != nil {
+			log.Printf("%s: Restarting with %s", d.host, srv.host)
+			go d.restart()
+		}
+	}
+}
+
 func (srv *Server) loop() {
 	<-srv.started
 	err := srv.getError()
@@ -513,24 +910,49 @@ func (srv *Server) loop() {
 	}
 }
 
-func (srv *Server) stopProcess() (err error) {
+func (srv *Server) stopProcess(p *process) (err error) {
 	log.Println("Stopping process", srv.host)
+	if p == nil {
+		return nil
+	}
+
+	// The exit of a stopped process is not a crash, even when it exited on its own before
+	p.retire()
+
+	var survivors []string
+
 	select {
-	case err = <-srv.done:
+	case err = <-p.done:
 		log.Println("Process already stopped")
 	default:
-		if srv.getProcessState() == running {
-			srv.setProcessState(stopping)
-			srv.cmd.Process.Signal(syscall.SIGTERM)
+		if p.getState() == running {
+			p.setState(stopping)
+			p.signal(stopSignals[p.conf.Stop.Signal])
 			select {
-			case err = <-srv.done:
-			case <-time.After(srv.startupTimeout):
-				srv.cmd.Process.Signal(syscall.SIGKILL)
+			case err = <-p.done:
+			case <-time.After(p.conf.Stop.Grace * time.Second):
+				survivors = p.members()
+				p.signal(syscall.SIGKILL)
 				// TODO : We may need to set a timeout here
-				err = <-srv.done
+				err = <-p.done
 			}
 		}
 	}
+
+	if p.cmd != nil {
+		// The children may outlive the process and hold on to its port
+		if m := p.members(); len(m) > 0 {
+			survivors = append(survivors, m...)
+			p.signal(syscall.SIGKILL)
+		}
+	}
+
+	if len(survivors) > 0 {
+		report := fmt.Sprintf("%s: killed the processes that survived %s:\n%s", srv.host, p.conf.Stop.Signal, strings.Join(survivors, "\n"))
+		log.Println(report)
+		srv.addWarning(warningStop, report)
+	}
+
 	return err
 }
 
@@ -540,13 +962,15 @@ func (srv *Server) restart() error {
 		srv.started <- <-srv.busy
 	}()
 
-	err := srv.stop()
-	srv.setError(err)
-	if err != nil {
-		return err
-	}
+	return srv.restartProcess()
+}
+
+// restartProcess stops and starts the server. It must be called while the server is busy
+func (srv *Server) restartProcess() error {
+	// A failing postStop hook is reported but does not prevent the restart
+	srv.setError(srv.stop())
 
-	err = srv.start()
+	err := srv.start()
 	if err != nil {
 		err = fmt.Errorf("%v\nError:%s\n", err, srv.stderr.ReadAll())
 	}
@@ -554,54 +978,84 @@ func (srv *Server) restart() error {
 	return err
 }
 
-func (srv *Server) startProcess() error {
-	log.Println("Starting Process: ", srv.addr)
-	srv.setProcessState(created)
-	ev := env.New(os.Environ())
-	for key, value := range srv.conf.Env {
-		ev.Set(key, value)
+func (srv *Server) startProcess(p *process) error {
+	log.Println("Starting Process: ", p.addr)
+	p.setState(created)
+
+	cmd := exec.Command(srv.bin, p.conf.Startup...)
+	ev := processEnv(p.conf)
+
+	if p.conf.SocketActivation {
+		f, err := srv.activationSocket()
+		if err != nil {
+			return err
+		}
+		// The process holds its own copy once started
+		defer f.Close()
+
+		cmd = socketActivationCommand(srv.bin, p.conf.Startup...)
+		cmd.ExtraFiles = []*os.File{f}
+		ev.Set(envListenFds, "1")
+	}
+
+	stdout := srv.stdout
+	if srv.conf.Replicas > 1 {
+		// Tell the replicas apart in the output
+		stdout = logger.NewLogWriter(os.Stdout, fmt.Sprintf("%s[%d]> ", srv.host, p.index), log.LstdFlags)
+		ev.Set(envReplica, strconv.Itoa(p.index))
 	}
 
-	cmd := exec.Command(srv.bin, srv.startup...)
 	cmd.Env = ev.Data()
+	// Run in a process group of its own so that the children can be stopped along with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Stderr = srv.stderr
-	cmd.Stdout = srv.stdout
+	cmd.Stdout = stdout
+
+	var output []*logMatcher
+
+	if srv.healthPattern != nil {
+		// One matcher per stream so that their lines are not mixed
+		output = []*logMatcher{newLogMatcher(srv.healthPattern), newLogMatcher(srv.healthPattern)}
+		cmd.Stdout = io.MultiWriter(stdout, output[0])
+		cmd.Stderr = io.MultiWriter(srv.stderr, output[1])
+	}
 
 	err := cmd.Start()
 	if err == nil {
-		srv.setProcessState(running)
-		srv.cmd = cmd
+		p.setState(running)
+		p.cmd = cmd
 
 		go func() {
 			status := cmd.Wait()
 
 			log.Println(srv.host, "->", status)
-			srv.done <- nil
-			oldState := srv.getProcessState()
-			srv.setProcessState(exited)
+			p.done <- nil
+			oldState := p.getState()
+			p.setState(exited)
 
-			select {
-			case srv.busy <- true:
-				if oldState == running {
-					// The process crashed or was killed externally
-					// Restart it
-					// TODO: limit the number of consecutive restart
-					if srv.stderr.Len() == 0 || strings.Contains(status.Error(), "terminated") {
-						go srv.restart()
-					} else {
-						go srv.stopAndNotify()
-					}
-				}
+			if oldState != running {
+				// Stopped by livedev or failed to start
+				return
+			}
+
+			// Wait for the current build or restart to complete. It may have replaced the process
+			srv.busy <- true
+			defer func() {
 				<-srv.busy
-			default:
+			}()
+
+			if srv.hasProcess(p) && !p.isRetired() {
+				// The process crashed or was killed externally
+				srv.onExit(p, status)
 			}
 		}()
 
-		err = <-srv.testConnection(&url.URL{
-			Host:   srv.addr,
-			Scheme: "http",
-			Path:   "/",
-		}, srv.startupTimeout*time.Second)
+		if srv.background {
+			// Nothing to connect to. The process is supervised through its exit status
+			return nil
+		}
+
+		err = <-srv.testConnection(p, srv.readinessProbe(p, output), srv.startupTimeout*time.Second, srv.conf.HealthCheck.Interval*time.Millisecond)
 	}
 	return err
 
@@ -613,7 +1067,19 @@ func (srv *Server) build() error {
 	// List of file to pass to "go build"
 	var buildFiles []string
 
-	dep, err := computeDep(&srv.context, srv.target)
+	if err := srv.runHooks("preBuild", srv.conf.PreBuild); err != nil {
+		return err
+	}
+
+	if len(srv.target) == 0 && len(srv.pkg) == 0 {
+		// Nothing to compile. The binary is run as is
+		return srv.runHooks("postBuild", srv.conf.PostBuild)
+	}
+
+	mod := findModule(srv.target)
+	srv.setModule(mod)
+
+	dep, tests, err := computeDep(&srv.context, mod, srv.target, srv.pkg)
 	if err != nil {
 		return err
 	}
@@ -622,6 +1088,21 @@ func (srv *Server) build() error {
 		srv.unwatch(f)
 	}
 
+	for f := range srv.tests {
+		srv.unwatch(f)
+	}
+
+	// Changes to the test files only run the tests
+	srv.tests = make(map[string]struct{})
+	if srv.conf.Test != nil {
+		for _, f := range tests {
+			srv.tests[f] = struct{}{}
+			if err := srv.watch(f); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Reset the dependency list.
 	srv.dep = make(map[string]struct{})
 	for _, f := range dep {
@@ -630,22 +1111,64 @@ func (srv *Server) build() error {
 			return err
 		}
 
-		if filepath.Dir(f) == srv.targetDir {
+		if len(srv.pkg) == 0 && filepath.Dir(f) == srv.targetDir && filepath.Ext(f) == ".go" {
 			buildFiles = append(buildFiles, filepath.Base(f))
 		}
 	}
 
-	env := env.New(os.Environ())
-	env.Set("GOPATH", srv.context.GOPATH)
-
 	command, args := srv.builder[0], srv.builder[1:]
 
-	args = append(args, buildFiles...)
+	if len(srv.pkg) > 0 {
+		// Let the go command apply build constraints and embed directives
+		args = append(args, srv.pkg)
+	} else {
+		args = append(args, buildFiles...)
+	}
+
+	ev := goEnv(&srv.context, mod)
+
+	var key string
+
+	if srv.cache != nil {
+		if key, err = srv.buildKey(dep, append([]string{command}, args...), ev); err != nil {
+			log.Println("Build cache disabled:", err)
+		} else if found, err := srv.cache.Get(key, srv.bin); err != nil {
+			log.Println("Build cache error:", err)
+		} else if found {
+			log.Printf("Using cached build...%s", srv.host)
+			return srv.runHooks("postBuild", srv.conf.PostBuild)
+		}
+	}
 
 	cmd := exec.Command(command, args...)
-	cmd.Env = env.Data()
+	cmd.Env = ev.Data()
 	cmd.Dir = srv.conf.WorkingDir
 
+	var output string
+	if len(srv.conf.Builder) == 0 {
+		// Servers building the same package share the build of the go command
+		output = srv.bin
+	}
+
+	err = srv.builds.Run(buildID(cmd, output), srv.host, output, func() error {
+		return runBuilder(cmd)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if len(key) > 0 {
+		if err := srv.cache.Put(key, srv.bin); err != nil {
+			log.Println("Build cache error:", err)
+		}
+	}
+
+	return srv.runHooks("postBuild", srv.conf.PostBuild)
+}
+
+// runBuilder runs the build command and returns its output as the error on failure
+func runBuilder(cmd *exec.Cmd) error {
 	if out, err := cmd.CombinedOutput(); err != nil {
 		if len(out) > 0 {
 			r := bufio.NewReader(bytes.NewReader(out))
@@ -669,6 +1192,36 @@ func (srv *Server) build() error {
 	return nil
 }
 
+// processEnv returns the environment of the server process
+func processEnv(conf *serverConfig) *env.Env {
+	ev := env.New(os.Environ())
+	for key, value := range conf.Env {
+		ev.Set(key, value)
+	}
+	return ev
+}
+
+// hookEnv returns the environment of the hook commands.
+// It is the server's environment along with the go command settings
+func (srv *Server) hookEnv() *env.Env {
+	ev := goEnv(&srv.context, srv.getModule())
+	for key, value := range srv.conf.Env {
+		ev.Set(key, value)
+	}
+	return ev
+}
+
+// srcDirs returns the directories used to resolve source files referenced in error messages
+func (srv *Server) srcDirs() []string {
+	dirs := append(srv.context.SrcDirs(), srv.targetDir)
+
+	if mod := srv.getModule(); mod != nil {
+		dirs = append(dirs, mod.Dirs()...)
+	}
+
+	return dirs
+}
+
 func (srv *Server) onUpdate() <-chan struct{} {
 	return srv.updateListeners.register()
 }
@@ -678,7 +1231,7 @@ func (srv *Server) handleLivedevSocket(w http.ResponseWriter, r *http.Request) e
 
 	if err == nil {
 		// For now, no need for a full websocket protocol implementation
-		// We need just enough to maintain the connection
+		// We need just enough to maintain the connection and send test results
 		// Communicate changes to the caller by just closing the conntection
 		go func() {
 			code := http.StatusSwitchingProtocols
@@ -691,6 +1244,8 @@ func (srv *Server) handleLivedevSocket(w http.ResponseWriter, r *http.Request) e
 			buf.Flush()
 			done := make(chan bool, 1)
 			update := srv.onUpdate()
+			messages := srv.statusListeners.register()
+			defer srv.statusListeners.remove(messages)
 
 			go func() {
 				// We do not expect any message from the client
@@ -699,9 +1254,18 @@ func (srv *Server) handleLivedevSocket(w http.ResponseWriter, r *http.Request) e
 				done <- true
 			}()
 
-			select {
-			case <-update:
-			case <-done:
+		loop:
+			for {
+				select {
+				case msg := <-messages:
+					if err := writeWebSocketText(client, msg); err != nil {
+						break loop
+					}
+				case <-update:
+					break loop
+				case <-done:
+					break loop
+				}
 			}
 			client.Close()
 		}()
@@ -709,7 +1273,7 @@ func (srv *Server) handleLivedevSocket(w http.ResponseWriter, r *http.Request) e
 	return err
 }
 
-func (srv *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) error {
+func (srv *Server) serveWebSocket(p *process, w http.ResponseWriter, r *http.Request) error {
 	client, buf, err := w.(http.Hijacker).Hijack()
 
 	if err != nil {
@@ -718,7 +1282,7 @@ func (srv *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) error
 
 	// We have taken over the connection from this point on. Do not return any error to the caller
 	requestURL := *r.URL
-	requestURL.Host = srv.addr
+	requestURL.Host = p.addr
 
 	conn, err := net.Dial(client.LocalAddr().Network(), requestURL.Host)
 
@@ -758,6 +1322,11 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
 	isWS := r.Header.Get("Upgrade") == "websocket"
 	isLiveReload := isWS && r.Header.Get("Sec-WebSocket-Protocol") == liveReloadProtocol
 
+	// Streaming calls can not be buffered
+	if srv.conf.Hold != nil && !isWS && srv.conf.Protocol != protocolH2C {
+		return srv.serveHeld(w, r)
+	}
+
 	err := <-srv.ready
 
 	if isLiveReload {
@@ -768,23 +1337,54 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	srv.pending.Add(1)
-	defer srv.pending.Done()
+	p := srv.pickProcess(r)
+	if p == nil {
+		return errors.New("Server not started")
+	}
+
+	p.acquire()
+	defer p.release()
 
 	if isWS {
-		return srv.serveWebSocket(w, r)
+		return srv.serveWebSocket(p, w, r)
 	}
 
+	response, err := srv.roundTrip(p, r)
+
+	if err != nil {
+		return fmt.Errorf("%s\n%s\n", err.Error(), srv.stderr.ReadAll())
+	}
+
+	defer response.Body.Close()
+	srv.stick(w, r, p)
+
+	return srv.writeResponse(w, response)
+}
+
+// roundTrip forwards r to the process
+func (srv *Server) roundTrip(p *process, r *http.Request) (*http.Response, error) {
 	req := new(http.Request)
 	*req = *r
-	req.Host = srv.addr
-	req.URL.Host = srv.addr
+	req.Header = r.Header.Clone()
+	removeHopHeaders(req.Header)
+
+	if strings.Contains(strings.ToLower(r.Header.Get("Te")), "trailers") {
+		// gRPC requires it
+		req.Header.Set("Te", "trailers")
+	}
+
+	req.Host = p.addr
+	req.URL.Host = p.addr
 	req.Proto = "HTTP/1.1"
 	req.Close = false
 	req.ProtoMajor = 1
 	req.ProtoMinor = 1
 	transport := http.DefaultTransport
 
+	if srv.conf.Protocol == protocolH2C || srv.conf.HTTP2 {
+		transport = h2cTransport
+	}
+
 	if len(req.URL.Scheme) == 0 {
 		req.URL.Scheme = "http"
 	}
@@ -793,16 +1393,22 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
 		req.Header.Set("X-Forwarded-For", ip)
 	}
 
-	response, err := transport.RoundTrip(req)
-
-	if err != nil {
-		return fmt.Errorf("%s\n%s\n", err.Error(), srv.stderr.ReadAll())
+	if r.TLS != nil {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		req.Header.Set("X-Forwarded-Proto", "http")
 	}
 
-	defer response.Body.Close()
+	return transport.RoundTrip(req)
+}
 
+// writeResponse copies the response of the process to w, injecting the live reload script into HTML pages
+func (srv *Server) writeResponse(w http.ResponseWriter, response *http.Response) error {
+	var err error
 	wh := w.Header()
 
+	removeHopHeaders(response.Header)
+
 	for key, v := range response.Header {
 		for _, value := range v {
 			wh.Add(key, value)
@@ -820,7 +1426,7 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
 				return err
 			}
 		}
-		body, contentLen, err = appendLiveScript(body, srv.proxyPort)
+		body, contentLen, err = appendLiveScript(body, srv.proxyPort, srv.tlsPort)
 
 		if err != nil {
 			return err
@@ -829,25 +1435,40 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
 			gw := gzip.NewWriter(w)
 			defer gw.Close()
 			w = responseWriter{gw, w}
+			// The compressed length is not known in advance
+			wh.Del("Content-Length")
+		} else {
+			wh.Set("Content-Length", strconv.Itoa(contentLen))
 		}
-
-		wh.Set("Content-Length", strconv.Itoa(contentLen))
 	}
 
 	w.WriteHeader(response.StatusCode)
-	if _, err := io.Copy(w, body); err != nil {
+
+	dst := io.Writer(w)
+	if srv.conf.Protocol == protocolH2C {
+		// Streaming calls expect each message as soon as it is sent
+		dst = newFlushWriter(w)
+	}
+
+	if _, err := io.Copy(dst, body); err != nil {
 		return fmt.Errorf("%s\n%s\n", err.Error(), srv.stderr.ReadAll())
 	}
 
+	for key, v := range response.Trailer {
+		for _, value := range v {
+			wh.Add(http.TrailerPrefix+key, value)
+		}
+	}
+
 	return nil
 }
 
-func appendLiveScript(reader io.Reader, port int) (io.Reader, int, error) {
+func appendLiveScript(reader io.Reader, port, tlsPort int) (io.Reader, int, error) {
 
 	data, err := ioutil.ReadAll(reader)
 
 	if err == nil {
-		data = appendHTML(data, []byte(fmt.Sprintf(liveReloadHTML, port)))
+		data = appendHTML(data, []byte(fmt.Sprintf(liveReloadHTML, port, tlsPort)))
 	}
 
 	if err != nil {
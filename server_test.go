@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteResponseHTTP2(t *testing.T) {
+	var page bytes.Buffer
+	gw := gzip.NewWriter(&page)
+	gw.Write([]byte("<html><body>Hello</body></html>"))
+	gw.Close()
+
+	srv := &Server{proxyPort: 8080, tlsPort: 8443}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Content-Type":     {"text/html"},
+				"Content-Encoding": {"gzip"},
+				"Content-Length":   {"1"},
+				"Connection":       {"keep-alive"},
+			},
+			Body: ioutil.NopCloser(bytes.NewReader(page.Bytes())),
+		}
+
+		if err := srv.writeResponse(w, response); err != nil {
+			t.Error(err)
+		}
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2 got %s", resp.Proto)
+	}
+
+	if !strings.Contains(string(body), "Hello") || !strings.Contains(string(body), "wss://") {
+		t.Fatalf("Expected the live reload script in %q", body)
+	}
+}
+
+func TestStartBackgroundProcess(t *testing.T) {
+	var conf config
+	input := `{"process": [{"name": "queue-worker", "bin": "/bin/sh", "startup": ["-c", "sleep 10"]}]}`
+
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := newServer(build.Default, conf.Processes[0], 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.background = true
+
+	if err := srv.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := srv.getProcess()
+	defer srv.stopProcess(p)
+
+	if p.getState() != running {
+		t.Fatal("Expected the process to be running")
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	srv := &Server{}
+	srv.setWarning(warningVet, "vet finding")
+	srv.addWarning(warningStop, "survivor 1")
+	srv.addWarning(warningStop, "survivor 2")
+
+	if w := srv.getWarning(); w != "survivor 1\nsurvivor 2\nvet finding" {
+		t.Fatalf("Unexpected warnings %q", w)
+	}
+
+	// Each source replaces only its own warning
+	srv.setWarning(warningVet, "")
+	if w := srv.getWarning(); w != "survivor 1\nsurvivor 2" {
+		t.Fatalf("Unexpected warnings %q", w)
+	}
+}
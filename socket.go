@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// activationSocket returns a copy of the listening socket of the server, creating it on first use.
+// The socket outlives the process so that connections wait in the backlog during restarts.
+func (srv *Server) activationSocket() (*os.File, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.listener == nil {
+		l, err := net.Listen("tcp", net.JoinHostPort(srv.processHost(), strconv.Itoa(srv.port)))
+		if err != nil {
+			return nil, err
+		}
+
+		tl, ok := l.(*net.TCPListener)
+		if !ok {
+			l.Close()
+			return nil, errors.New("Unable to create a tcp listener")
+		}
+
+		log.Println(srv.host, "Listening on", l.Addr())
+		srv.listener = tl
+	}
+
+	return srv.listener.File()
+}
+
+func (srv *Server) closeActivationSocket() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.listener != nil {
+		srv.listener.Close()
+		srv.listener = nil
+	}
+}
+
+// socketActivationCommand returns the command that runs bin with the given arguments.
+// LISTEN_PID must be the pid of the process, which is only known once it runs.
+// A shell sets it before replacing itself with the server.
+func socketActivationCommand(bin string, args ...string) *exec.Cmd {
+	script := envListenPid + `=$$; export ` + envListenPid + `; exec "$0" "$@"`
+	return exec.Command("/bin/sh", append([]string{"-c", script, bin}, args...)...)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestSocketActivationProcess is run by TestSocketActivation as the activated server
+func TestSocketActivationProcess(t *testing.T) {
+	if os.Getenv("LIVEDEV_TEST_ACTIVATION") != "1" {
+		t.Skip("Run by TestSocketActivation")
+	}
+
+	l, err := net.FileListener(os.NewFile(3, "socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "%s %s %d", os.Getenv(envListenFds), os.Getenv(envListenPid), os.Getpid())
+}
+
+func TestSocketActivation(t *testing.T) {
+	srv := &Server{host: "localhost"}
+	defer srv.closeActivationSocket()
+
+	f, err := srv.activationSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	addr := srv.listener.Addr().String()
+
+	// The socket outlives the process
+	again, err := srv.activationSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Close()
+
+	if a := srv.listener.Addr().String(); a != addr {
+		t.Fatalf("Expected the socket to be reused: %s != %s", a, addr)
+	}
+
+	cmd := socketActivationCommand(os.Args[0], "-test.run=^TestSocketActivationProcess$")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(), "LIVEDEV_TEST_ACTIVATION=1", envListenFds+"=1")
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 || fields[0] != "1" || fields[1] != fields[2] || fields[1] != fmt.Sprint(cmd.Process.Pid) {
+		t.Fatalf("Unexpected activation environment %q for pid %d", data, cmd.Process.Pid)
+	}
+}
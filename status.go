@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+const (
+	statusRunning = "running"
+	statusPassed  = "passed"
+	statusFailed  = "failed"
+	statusWarning = "warning"
+)
+
+// statusMessage is sent to the live reload clients to report the result of a build step
+type statusMessage struct {
+	Type     string   `json:"type"`
+	Status   string   `json:"status"`
+	Packages []string `json:"packages"`
+	Output   string   `json:"output,omitempty"`
+	URL      string   `json:"url,omitempty"`
+}
+
+type messageListeners struct {
+	mu        sync.Mutex
+	last      map[string][]byte
+	listeners map[chan []byte]struct{}
+}
+
+func newMessageListeners() *messageListeners {
+	return &messageListeners{
+		last:      make(map[string][]byte),
+		listeners: make(map[chan []byte]struct{}),
+	}
+}
+
+// register returns a channel that receives the last message of each kind followed by the upcoming messages
+func (m *messageListeners) register() chan []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan []byte, 8)
+	for _, msg := range m.last {
+		ch <- msg
+	}
+	m.listeners[ch] = struct{}{}
+	return ch
+}
+
+func (m *messageListeners) remove(ch chan []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.listeners, ch)
+}
+
+func (m *messageListeners) notify(kind string, msg []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.last[kind] = msg
+	for ch := range m.listeners {
+		select {
+		case ch <- msg:
+		default:
+			// Drop the oldest message the listener has not consumed yet
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- msg
+		}
+	}
+}
+
+// viewerURL returns the address of the warnings page, relative to the host of the live reloaded page
+func (srv *Server) viewerURL() string {
+	return fmt.Sprintf(":%d%s", srv.viewerPort, srv.viewerPath())
+}
+
+// viewerPath returns the path of the server in the code viewer
+func (srv *Server) viewerPath() string {
+	return "/" + url.PathEscape(srv.host) + "/"
+}
+
+func (srv *Server) notifyStatus(msg *statusMessage) {
+	if data, err := json.Marshal(msg); err == nil {
+		srv.statusListeners.notify(msg.Type, data)
+	}
+}
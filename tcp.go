@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// listenAndServeTCP forwards the connections accepted on the listen port to the server process
+func (srv *Server) listenAndServeTCP() error {
+	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(srv.conf.Listen)))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	log.Printf("%s: Forwarding tcp connections from %v", srv.host, l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.serveTCP(conn)
+	}
+}
+
+func (srv *Server) serveTCP(client net.Conn) {
+	defer client.Close()
+	srv.runOnce()
+
+	var timeout <-chan time.Time
+	if srv.conf.Hold != nil {
+		timeout = time.After(srv.conf.Hold.Timeout * time.Second)
+	}
+
+	var err error
+
+	// Connections wait while the server restarts
+	select {
+	case err = <-srv.ready:
+	case <-timeout:
+		err = errHoldTimeout
+	}
+
+	if err != nil {
+		log.Printf("%s: Closing connection from %v: %v", srv.host, client.RemoteAddr(), err)
+		return
+	}
+
+	p := srv.pickProcess(nil)
+	if p == nil {
+		return
+	}
+
+	// The connection is not tracked as pending: long lived connections would hold restarts.
+	// It ends along with the process instead.
+	atomic.AddInt32(&p.active, 1)
+	defer atomic.AddInt32(&p.active, -1)
+
+	upstream, err := net.Dial("tcp", p.addr)
+	if err != nil {
+		log.Printf("%s: Closing connection from %v: %v", srv.host, client.RemoteAddr(), err)
+		return
+	}
+	defer upstream.Close()
+
+	go func() {
+		io.Copy(upstream, client)
+		if c, ok := upstream.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+	}()
+
+	io.Copy(client, upstream)
+}
@@ -29,7 +29,7 @@ var errTemplate = template.Must(template.New("error").Parse(`
 		<code>
 		{{ range .Data}}
 			{{ if .Link}}
-				<a href="//{{.Link}}:{{.Line}}#L{{.Line}}">{{.Text}}</a>
+				<a href="http://{{.Link}}:{{.Line}}#L{{.Line}}">{{.Text}}</a>
 			{{else}}
 				<span>{{.Text}}</span>
 			{{end}}
@@ -65,6 +65,39 @@ func fileExists(name string) bool {
 	return true
 }
 
+// copyFile replaces dst with a copy of the executable src. dst is replaced atomically in case it is in use
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp := dst + ".tmp"
+	w, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
 var tags = [][]byte{
 	bytesReverse([]byte("</HTML>")),
 	bytesReverse([]byte("</BODY>")),
@@ -125,6 +158,26 @@ func writeWebSocketError(w io.Writer, err error, code int) {
 	b.Flush()
 }
 
+// writeWebSocketText writes data as a single unmasked websocket text frame
+func writeWebSocketText(w io.Writer, data []byte) error {
+	header := []byte{0x81}
+
+	switch n := len(data); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		for i := 7; i >= 0; i-- {
+			header = append(header, byte(uint64(n)>>(uint(i)*8)))
+		}
+	}
+
+	_, err := w.Write(append(header, data...))
+	return err
+}
+
 const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
 
 func generateWebsocketAcceptKey(key string) string {
@@ -133,3 +186,27 @@ func generateWebsocketAcceptKey(key string) string {
 	hash.Write([]byte(websocketGUID))
 	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
 }
+
+// hopHeaders are the connection specific headers that are not forwarded. HTTP/2 forbids them
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders removes the connection specific headers of h, including those listed by its Connection header
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// runVet runs go vet and the configured analyzers on the given package directories.
+// It returns their findings, if any.
+func (srv *Server) runVet(dirs []string) string {
+	log.Printf("Vetting...%s %v", srv.host, dirs)
+
+	args := []string{goTool(&srv.context), "vet"}
+	if len(srv.conf.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(srv.conf.Tags, ","))
+	}
+
+	commands := [][]string{append(args, srv.conf.Vet.Args...)}
+	commands = append(commands, srv.conf.Vet.Analyzers...)
+
+	var findings []string
+
+	for _, c := range commands {
+		if len(c) == 0 {
+			continue
+		}
+
+		var out bytes.Buffer
+		cmd := exec.Command(c[0], append(c[1:len(c):len(c)], dirs...)...)
+		cmd.Env = srv.hookEnv().Data()
+		cmd.Dir = srv.conf.WorkingDir
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+
+		if err := cmd.Run(); err != nil {
+			var lines []string
+			for _, line := range strings.SplitAfter(out.String(), "\n") {
+				if len(line) > 0 && !strings.HasPrefix(line, "#") {
+					lines = append(lines, line)
+				}
+			}
+
+			if len(lines) == 0 {
+				lines = append(lines, err.Error()+"\n")
+			}
+
+			findings = append(findings, fmt.Sprintf("%s:\n%s", strings.Join(c, " "), strings.Join(lines, "")))
+		}
+	}
+
+	status := &statusMessage{Type: "vet", Status: statusPassed, Packages: dirs}
+
+	if len(findings) > 0 {
+		status.Status = statusFailed
+		status.Output = strings.Join(findings, "\n")
+
+		if !srv.conf.Vet.Block {
+			status.Status = statusWarning
+			status.URL = srv.viewerURL()
+		}
+	}
+
+	srv.notifyStatus(status)
+	return status.Output
+}